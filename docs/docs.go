@@ -105,6 +105,18 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only tasks assigned to this user ID",
+                        "name": "assignee",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to priority to order by priority, due date, then position",
+                        "name": "order",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -222,30 +234,86 @@ const docTemplate = `{
                 }
             }
         },
-        "/task-items": {
+        "/categories/{id}/tags": {
             "get": {
-                "description": "Get a list of all task items",
+                "description": "Get how many active tasks of a category carry each tag",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "task-items"
+                    "categories"
+                ],
+                "summary": "Get tag counts of a category",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Get all task items",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/main.TaskItem"
+                                "$ref": "#/definitions/main.TagCount"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/categories/{id}/workflow": {
+            "get": {
+                "description": "Get the task state machine used by a category (the default workflow if none is configured)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "categories"
+                ],
+                "summary": "Get category workflow",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Workflow"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
                             }
                         }
                     }
                 }
             },
-            "post": {
-                "description": "Create a new task item",
+            "put": {
+                "description": "Replace the task state machine of a category. Tasks in states that no longer exist are moved to the initial state.",
                 "consumes": [
                     "application/json"
                 ],
@@ -253,25 +321,32 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "task-items"
+                    "categories"
                 ],
-                "summary": "Create task item",
+                "summary": "Update category workflow",
                 "parameters": [
                     {
-                        "description": "Task item data",
-                        "name": "item",
+                        "type": "string",
+                        "description": "Category ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Workflow definition",
+                        "name": "workflow",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.TaskItem"
+                            "$ref": "#/definitions/main.Workflow"
                         }
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.TaskItem"
+                            "$ref": "#/definitions/main.Workflow"
                         }
                     },
                     "400": {
@@ -282,38 +357,56 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
                 }
             }
         },
-        "/task-items/{id}": {
+        "/me/tasks": {
             "get": {
-                "description": "Get a single task item by ID",
+                "description": "Get active tasks assigned to the current user (X-User-ID header)",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "task-items"
+                    "assignments"
                 ],
-                "summary": "Get task item by ID",
+                "summary": "Get my tasks",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Task item ID",
-                        "name": "id",
-                        "in": "path",
+                        "description": "Current user ID",
+                        "name": "X-User-ID",
+                        "in": "header",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Set to priority to order by priority, due date, then position",
+                        "name": "order",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.TaskItem"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.TaskResponse"
+                            }
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -322,42 +415,85 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "put": {
-                "description": "Update an existing task item",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
+            }
+        },
+        "/media/{filepath}": {
+            "get": {
+                "description": "Serve an uploaded file through a link from a task or upload response. The link expires; request the task again for a fresh one. Accepts the same variant and transform parameters as /static and supports Range requests and the same caching headers.",
                 "tags": [
-                    "task-items"
+                    "uploads"
                 ],
-                "summary": "Update task item",
+                "summary": "Serve uploaded file by signed link",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Task item ID",
-                        "name": "id",
+                        "description": "File name",
+                        "name": "filepath",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Updated task item data",
-                        "name": "item",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/main.TaskItem"
-                        }
+                        "type": "integer",
+                        "description": "Expiry, unix seconds",
+                        "name": "exp",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Signature",
+                        "name": "sig",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Image variant name",
+                        "name": "variant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Transform: width in pixels",
+                        "name": "w",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Transform: height in pixels",
+                        "name": "h",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Transform: contain (default), cover or fill",
+                        "name": "fit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Transform: JPEG quality 1-100 (default 85)",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Transform: jpeg, png or webp (lossless); chosen from the Accept header when omitted",
+                        "name": "format",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.TaskItem"
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
                         }
                     },
                     "400": {
@@ -369,6 +505,15 @@ const docTemplate = `{
                             }
                         }
                     },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
@@ -379,28 +524,84 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Delete a task item and its associated file",
-                "produces": [
-                    "application/json"
-                ],
+            }
+        },
+        "/static/{filepath}": {
+            "get": {
+                "description": "Serve an uploaded file. For images, ?variant=thumb|medium|full returns the resized copy, falling back to the stored file. With w, h, fit, q or format an image is resized and re-encoded on the fly; results are cached on disk. Images in a format the Accept header rules out are converted. Range requests are supported for seeking in audio and video; content-addressed files are sent as immutable with the content hash as ETag. Closed with MEDIA_PUBLIC_STATIC=false; then use the signed /media links from responses.",
                 "tags": [
-                    "task-items"
+                    "uploads"
                 ],
-                "summary": "Delete task item",
+                "summary": "Serve uploaded file",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Task item ID",
-                        "name": "id",
+                        "description": "File name",
+                        "name": "filepath",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Image variant name",
+                        "name": "variant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Transform: width in pixels",
+                        "name": "w",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Transform: height in pixels",
+                        "name": "h",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Transform: contain (default), cover or fill",
+                        "name": "fit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Transform: JPEG quality 1-100 (default 85)",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Transform: jpeg, png or webp (lossless); chosen from the Accept header when omitted",
+                        "name": "format",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -420,30 +621,30 @@ const docTemplate = `{
                 }
             }
         },
-        "/tasks": {
+        "/tags": {
             "get": {
-                "description": "Get a list of all active tasks ordered by position",
+                "description": "Get a list of all tags",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tasks"
+                    "tags"
                 ],
-                "summary": "Get all tasks",
+                "summary": "Get all tags",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/main.TaskResponse"
+                                "$ref": "#/definitions/main.Tag"
                             }
                         }
                     }
                 }
             },
             "post": {
-                "description": "Create a new task with category, name, and optional position",
+                "description": "Create a tag with a name and a #RRGGBB color",
                 "consumes": [
                     "application/json"
                 ],
@@ -451,32 +652,23 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "tasks"
+                    "tags"
                 ],
-                "summary": "Create a new task",
+                "summary": "Create a new tag",
                 "parameters": [
                     {
-                        "description": "Task data",
-                        "name": "task",
+                        "description": "Tag data",
+                        "name": "tag",
                         "in": "body",
                         "required": true,
                         "schema": {
                             "type": "object",
                             "properties": {
-                                "category_id": {
+                                "color": {
                                     "type": "string"
                                 },
-                                "is_success": {
-                                    "type": "boolean"
-                                },
                                 "name": {
                                     "type": "string"
-                                },
-                                "position": {
-                                    "type": "integer"
-                                },
-                                "price": {
-                                    "type": "number"
                                 }
                             }
                         }
@@ -486,7 +678,7 @@ const docTemplate = `{
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/main.TaskResponse"
+                            "$ref": "#/definitions/main.Tag"
                         }
                     },
                     "400": {
@@ -497,57 +689,43 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
                 }
             }
         },
-        "/tasks/deleted": {
+        "/tags/{id}": {
             "get": {
-                "description": "Get a list of all soft-deleted tasks",
+                "description": "Get a single tag by its ID",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tasks"
+                    "tags"
+                ],
+                "summary": "Get tag by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
                 ],
-                "summary": "Get deleted tasks",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/main.TaskResponse"
-                            }
-                        }
-                    }
-                }
-            }
-        },
-        "/tasks/{id}": {
-            "get": {
-                "description": "Get a single task by its ID",
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "tasks"
-                ],
-                "summary": "Get task by ID",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Task ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/main.TaskResponse"
+                            "$ref": "#/definitions/main.Tag"
                         }
                     },
                     "404": {
@@ -562,7 +740,7 @@ const docTemplate = `{
                 }
             },
             "put": {
-                "description": "Update an existing task",
+                "description": "Rename or recolor a tag",
                 "consumes": [
                     "application/json"
                 ],
@@ -570,36 +748,30 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "tasks"
+                    "tags"
                 ],
-                "summary": "Update task",
+                "summary": "Update tag",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Task ID",
+                        "description": "Tag ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Updated task data",
-                        "name": "task",
+                        "description": "Updated tag data",
+                        "name": "tag",
                         "in": "body",
                         "required": true,
                         "schema": {
                             "type": "object",
                             "properties": {
-                                "category_id": {
+                                "color": {
                                     "type": "string"
                                 },
-                                "is_success": {
-                                    "type": "boolean"
-                                },
                                 "name": {
                                     "type": "string"
-                                },
-                                "price": {
-                                    "type": "number"
                                 }
                             }
                         }
@@ -609,7 +781,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.TaskResponse"
+                            "$ref": "#/definitions/main.Tag"
                         }
                     },
                     "400": {
@@ -629,22 +801,31 @@ const docTemplate = `{
                                 "type": "string"
                             }
                         }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
                     }
                 }
             },
             "delete": {
-                "description": "Soft delete a task (can be restored later)",
+                "description": "Delete a tag and detach it from all tasks",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tasks"
+                    "tags"
                 ],
-                "summary": "Soft delete task",
+                "summary": "Delete tag",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Task ID",
+                        "description": "Tag ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -672,25 +853,16 @@ const docTemplate = `{
                 }
             }
         },
-        "/tasks/{id}/items": {
+        "/task-items": {
             "get": {
-                "description": "Get all items for a specific task",
+                "description": "Get a list of all task items",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "task-items"
                 ],
-                "summary": "Get task items by task ID",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Task ID",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
-                    }
-                ],
+                "summary": "Get all task items",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -702,22 +874,63 @@ const docTemplate = `{
                         }
                     }
                 }
+            },
+            "post": {
+                "description": "Create a new task item. Pass upload_id to attach a registered upload; data is then set to its URL. Checklist items always start open; done, done_by and done_at are ignored, use PUT /task-items/{id}/check.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "task-items"
+                ],
+                "summary": "Create task item",
+                "parameters": [
+                    {
+                        "description": "Task item data",
+                        "name": "item",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskItem"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskItem"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
             }
         },
-        "/tasks/{id}/permanent": {
-            "delete": {
-                "description": "Permanently delete a task and all its items",
+        "/task-items/{id}": {
+            "get": {
+                "description": "Get a single task item by ID",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tasks"
+                    "task-items"
                 ],
-                "summary": "Permanently delete task",
+                "summary": "Get task item by ID",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Task ID",
+                        "description": "Task item ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -727,10 +940,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.TaskItem"
                         }
                     },
                     "404": {
@@ -743,11 +953,9 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/tasks/{id}/position": {
+            },
             "put": {
-                "description": "Change the position of a task in the list",
+                "description": "Update an existing task item. Pass upload_id to attach a registered upload; data is then set to its URL.",
                 "consumes": [
                     "application/json"
                 ],
@@ -755,29 +963,24 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "tasks"
+                    "task-items"
                 ],
-                "summary": "Update task position",
+                "summary": "Update task item",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Task ID",
+                        "description": "Task item ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "New position",
-                        "name": "position",
+                        "description": "Updated task item data",
+                        "name": "item",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "type": "object",
-                            "properties": {
-                                "position": {
-                                    "type": "integer"
-                                }
-                            }
+                            "$ref": "#/definitions/main.TaskItem"
                         }
                     }
                 ],
@@ -785,7 +988,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.TaskResponse"
+                            "$ref": "#/definitions/main.TaskItem"
                         }
                     },
                     "400": {
@@ -807,22 +1010,20 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/tasks/{id}/restore": {
-            "put": {
-                "description": "Restore a soft-deleted task",
+            },
+            "delete": {
+                "description": "Delete a task item. A file no other item uses is removed by the upload garbage collector once the grace period has passed.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tasks"
+                    "task-items"
                 ],
-                "summary": "Restore deleted task",
+                "summary": "Delete task item",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Task ID",
+                        "description": "Task item ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -831,13 +1032,6 @@ const docTemplate = `{
                 "responses": {
                     "200": {
                         "description": "OK",
-                        "schema": {
-                            "type": "object",
-                            "additionalProperties": true
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -857,9 +1051,9 @@ const docTemplate = `{
                 }
             }
         },
-        "/tasks/{id}/success": {
+        "/task-items/{id}/check": {
             "put": {
-                "description": "Update task success status and price",
+                "description": "Mark a checklist task item as done or undone. If the task has auto_complete enabled and every checklist item is done, the task is marked successful.",
                 "consumes": [
                     "application/json"
                 ],
@@ -867,30 +1061,33 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "tasks"
+                    "task-items"
                 ],
-                "summary": "Mark task as success",
+                "summary": "Tick off checklist item",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Task ID",
+                        "description": "Task item ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "Success status and price",
-                        "name": "success",
+                        "type": "string",
+                        "description": "User completing the item",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Completion state",
+                        "name": "check",
                         "in": "body",
                         "required": true,
                         "schema": {
                             "type": "object",
                             "properties": {
-                                "is_success": {
+                                "done": {
                                     "type": "boolean"
-                                },
-                                "price": {
-                                    "type": "number"
                                 }
                             }
                         }
@@ -900,7 +1097,7 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.TaskResponse"
+                            "$ref": "#/definitions/main.TaskItem"
                         }
                     },
                     "400": {
@@ -924,165 +1121,2319 @@ const docTemplate = `{
                 }
             }
         },
-        "/upload/audio": {
-            "post": {
-                "description": "Upload an audio file in any format",
-                "consumes": [
-                    "multipart/form-data"
-                ],
+        "/tasks": {
+            "get": {
+                "description": "Get a list of all active tasks ordered by position (pinned tasks first)",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "uploads"
+                    "tasks"
                 ],
-                "summary": "Upload audio",
+                "summary": "Get all tasks",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "Audio file",
-                        "name": "audio",
-                        "in": "formData",
-                        "required": true
+                        "type": "string",
+                        "description": "Set to priority to order by priority, due date, then position",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Comma-separated tag IDs to filter by",
+                        "name": "tags",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "any (default) or all",
+                        "name": "tag_match",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.UploadResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.TaskResponse"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.UploadResponse"
-                        }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
-                        "schema": {
-                            "$ref": "#/definitions/main.UploadResponse"
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     }
                 }
-            }
-        },
-        "/upload/image": {
+            },
             "post": {
-                "description": "Upload an image file (JPEG, PNG, GIF, BMP, TIFF, WebP). HEIC/HEIF not supported.",
+                "description": "Create a new task with category, name, and optional position",
                 "consumes": [
-                    "multipart/form-data"
+                    "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "uploads"
+                    "tasks"
                 ],
-                "summary": "Upload image",
+                "summary": "Create a new task",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "Image file",
-                        "name": "image",
-                        "in": "formData",
-                        "required": true
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/main.UploadResponse"
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
+                        "description": "Task data",
+                        "name": "task",
+                        "in": "body",
+                        "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.UploadResponse"
-                        }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
+                            "type": "object",
+                            "properties": {
+                                "auto_complete": {
+                                    "type": "boolean"
+                                },
+                                "category_id": {
+                                    "type": "string"
+                                },
+                                "due_at": {
+                                    "type": "string"
+                                },
+                                "is_success": {
+                                    "type": "boolean"
+                                },
+                                "name": {
+                                    "type": "string"
+                                },
+                                "position": {
+                                    "type": "integer"
+                                },
+                                "price": {
+                                    "type": "number"
+                                },
+                                "priority": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/changes": {
+            "get": {
+                "description": "Changes to tasks after the given sequence number, oldest first. Poll with since set to the latest value of the previous response. Urgent tasks are flagged with urgent; urgent=true returns only those. When since is ahead of latest the server has restarted and the whole feed is returned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Task change feed",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Last sequence number seen",
+                        "name": "since",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only changes to urgent tasks",
+                        "name": "urgent",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ChangeFeed"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/deleted": {
+            "get": {
+                "description": "Get a list of all soft-deleted tasks",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Get deleted tasks",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.TaskResponse"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}": {
+            "get": {
+                "description": "Get a single task by its ID",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Get task by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Update an existing task. priority, due_at and auto_complete are kept when omitted; send \"due_at\": null to clear the due date.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Update task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Updated task data",
+                        "name": "task",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "auto_complete": {
+                                    "type": "boolean"
+                                },
+                                "category_id": {
+                                    "type": "string"
+                                },
+                                "due_at": {
+                                    "type": "string"
+                                },
+                                "is_success": {
+                                    "type": "boolean"
+                                },
+                                "name": {
+                                    "type": "string"
+                                },
+                                "price": {
+                                    "type": "number"
+                                },
+                                "priority": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Soft delete a task (can be restored later)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Soft delete task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/assignees": {
+            "put": {
+                "description": "Replace the assignees of a task, recording every handoff",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "assignments"
+                ],
+                "summary": "Reassign task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User making the change",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "New set of user IDs",
+                        "name": "assignees",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "user_ids": {
+                                    "type": "array",
+                                    "items": {
+                                        "type": "string"
+                                    }
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Add one or more assignees to a task",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "assignments"
+                ],
+                "summary": "Assign users to task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User making the change",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "User IDs to assign",
+                        "name": "assignees",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "user_ids": {
+                                    "type": "array",
+                                    "items": {
+                                        "type": "string"
+                                    }
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/assignees/{user_id}": {
+            "delete": {
+                "description": "Remove a single assignee from a task",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "assignments"
+                ],
+                "summary": "Unassign user from task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User making the change",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/assignments": {
+            "get": {
+                "description": "Get all assign/unassign events of a task, oldest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "assignments"
+                ],
+                "summary": "Get task assignment history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.AssignmentEvent"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/attachments": {
+            "post": {
+                "description": "Upload one or more files (repeat the files field) and add them to the task as image, audio or video items, positioned in the order sent. The kind is detected from each file's content. Either all files are attached or none: on any failure the files stored so far are removed again. Images that look like one already on the task come back with similar and warning set; they are attached anyway.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "task-items"
+                ],
+                "summary": "Upload and attach files to a task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Uploader, charged against the user quota",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Files to attach",
+                        "name": "files",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.TaskItem"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/items": {
+            "get": {
+                "description": "Get all items for a specific task",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "task-items"
+                ],
+                "summary": "Get task items by task ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.TaskItem"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/permanent": {
+            "delete": {
+                "description": "Permanently delete a task and all its items. Files no other item uses are left to the upload garbage collector.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Permanently delete task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/pin": {
+            "put": {
+                "description": "Pinned tasks are listed before all other tasks",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Pin or unpin task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Pin state",
+                        "name": "pin",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "pinned": {
+                                    "type": "boolean"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/position": {
+            "put": {
+                "description": "Change the position of a task in the list",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Update task position",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New position",
+                        "name": "position",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "position": {
+                                    "type": "integer"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/priority": {
+            "put": {
+                "description": "Set the priority of a task (urgent, high, normal or low)",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Update task priority",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New priority",
+                        "name": "priority",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "priority": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/restore": {
+            "put": {
+                "description": "Restore a soft-deleted task",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Restore deleted task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/success": {
+            "put": {
+                "description": "Update task success status and price. is_success=true moves the task to its workflow's success state, false back to the initial state.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Mark task as success",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Success status and price",
+                        "name": "success",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "is_success": {
+                                    "type": "boolean"
+                                },
+                                "price": {
+                                    "type": "number"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/tags": {
+            "post": {
+                "description": "Attach one or more existing tags to a task",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tags"
+                ],
+                "summary": "Add tags to task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Tag IDs to attach",
+                        "name": "tags",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "tag_ids": {
+                                    "type": "array",
+                                    "items": {
+                                        "type": "string"
+                                    }
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/tags/{tag_id}": {
+            "delete": {
+                "description": "Detach a tag from a task",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tags"
+                ],
+                "summary": "Remove tag from task",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tag ID",
+                        "name": "tag_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/transition": {
+            "post": {
+                "description": "Move a task to another workflow status. Moves not allowed by the category workflow are rejected.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Transition task status",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "User making the change",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Target status",
+                        "name": "transition",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "status": {
+                                    "type": "string"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TaskResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/tasks/{id}/transitions": {
+            "get": {
+                "description": "Get the current status of a task and the statuses it may move to",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tasks"
+                ],
+                "summary": "Get allowed transitions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Task ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/audio": {
+            "post": {
+                "description": "Upload an audio file (MP3, WAV, OGG, M4A, AAC, FLAC, AMR, WebM, WMA). The type is detected from the file content and must match the file extension. Duration and codec are read from MP3, WAV, OGG, M4A and WebM headers. A waveform (peak per slice, 0-1) is computed for WAV, and for other formats when ffmpeg is available; with AUDIO_TRANSCODE_FORMAT set the file is stored loudness-normalized in that format. Identical content is stored once: the existing upload is returned with duplicate set.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Upload audio",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Uploader, charged against the user quota",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Category charged against the category quota",
+                        "name": "category_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Audio file",
+                        "name": "audio",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/batch": {
+            "post": {
+                "description": "Upload up to UPLOAD_BATCH_MAX_FILES files of mixed kinds at once (repeat the files field). The kind of each file is detected from its content and it goes through the same pipeline as /upload/image, /upload/audio or /upload/video, UPLOAD_BATCH_WORKERS files at a time. Every file gets its own result, in the order sent; one failing file does not affect the others.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Upload many files",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Uploader, charged against the user quota",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Category charged against the category quota",
+                        "name": "category_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Task the files are for; images get a warning when a similar one is already on it",
+                        "name": "task_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Files",
+                        "name": "files",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.BatchUploadResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/image": {
+            "post": {
+                "description": "Upload an image file (JPEG, PNG, GIF, BMP, TIFF, WebP, HEIC/HEIF). The type is detected from the file content. The stored format follows IMAGE_STORAGE_FORMAT (original, jpeg, webp-lossless, webp-lossy); HEIC/HEIF is stored as JPEG when keeping the original. Metadata is always removed. Identical content is stored once: the existing upload is returned with duplicate set.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Upload image",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Uploader, charged against the user quota",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Category charged against the category quota",
+                        "name": "category_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Task the image is for; similar images already on it are returned with a warning",
+                        "name": "task_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Image file",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/upload/video": {
+            "post": {
+                "description": "Upload a video file (MP4, MOV, M4V, 3GP, WebM, MKV, AVI, FLV, WMV). The type is detected from the file content and must match the file extension. Duration, dimensions and codec are read from MP4/MOV and WebM/MKV headers. When ffmpeg is available a poster frame, and with VIDEO_PREVIEW=true a short animated GIF preview, are stored with the upload.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Upload video",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Uploader, charged against the user quota",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Category charged against the category quota",
+                        "name": "category_id",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "file",
+                        "description": "Video file",
+                        "name": "video",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/uploads": {
+            "get": {
+                "description": "Get registered uploads, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Get uploads",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only uploads by this user",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only uploads charged to this category",
+                        "name": "category_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Upload"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/uploads/gc": {
+            "post": {
+                "description": "Delete stored files that no task item references, and their upload records. Files newer than the grace period are kept. Files that are not uploads (not named by content hash and without an upload record) are never deleted and are counted in skipped_foreign.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Collect orphaned uploads",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Only report what would be deleted",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Grace period, e.g. 24h (default UPLOAD_GC_GRACE)",
+                        "name": "grace",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.GCReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/uploads/sessions": {
+            "post": {
+                "description": "Start a resumable upload session. Send the file with PATCH requests, then finalize it.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Create resumable upload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Uploader, charged against the user quota",
+                        "name": "X-User-ID",
+                        "in": "header"
+                    },
+                    {
+                        "description": "kind is image, audio or video; size is the total file size in bytes",
+                        "name": "session",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "properties": {
+                                "category_id": {
+                                    "type": "string"
+                                },
+                                "file_name": {
+                                    "type": "string"
+                                },
+                                "kind": {
+                                    "type": "string"
+                                },
+                                "size": {
+                                    "type": "integer"
+                                }
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadSession"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/uploads/sessions/{id}": {
+            "get": {
+                "description": "Get the current offset of an upload session (also returned in the Upload-Offset header, HEAD works too)",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Get resumable upload offset",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadSession"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete an upload session and the bytes uploaded so far",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Abort resumable upload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Append bytes to an upload session. Upload-Offset must equal the current offset. If the connection drops, the bytes received so far are kept; ask for the offset and resume from there.",
+                "consumes": [
+                    "application/offset+octet-stream"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Upload a chunk",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Offset this chunk starts at",
+                        "name": "Upload-Offset",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "423": {
+                        "description": "Locked",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/uploads/sessions/{id}/finalize": {
+            "post": {
+                "description": "Process a fully uploaded session like a regular upload and return the same UploadResponse",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Finalize resumable upload",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Session ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/main.UploadResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/uploads/usage": {
+            "get": {
+                "description": "Get bytes and files consumed by uploads, per user and per category, with the configured quotas",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
+                ],
+                "summary": "Get upload storage usage",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only this user",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only this category",
+                        "name": "category_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.UploadResponse"
+                            "$ref": "#/definitions/main.UsageReport"
                         }
                     }
                 }
             }
         },
-        "/upload/video": {
-            "post": {
-                "description": "Upload a video file in any format",
-                "consumes": [
-                    "multipart/form-data"
+        "/uploads/{id}": {
+            "get": {
+                "description": "Get a registered upload and its metadata",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "uploads"
                 ],
+                "summary": "Get upload by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Upload ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Upload"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/uploads/{id}/similar": {
+            "get": {
+                "description": "Find image uploads that look like this one (perceptual hash distance up to max_distance bits of 64), among the items of a task or of a category's tasks, closest first. Without task_id and category_id all task items are searched.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "uploads"
                 ],
-                "summary": "Upload video",
+                "summary": "Find similar images",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "Video file",
-                        "name": "video",
-                        "in": "formData",
+                        "type": "string",
+                        "description": "Upload ID",
+                        "name": "id",
+                        "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Search this task",
+                        "name": "task_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Search the tasks of this category",
+                        "name": "category_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Largest distance, 0-64 (default IMAGE_SIMILAR_DISTANCE)",
+                        "name": "max_distance",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.UploadResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.SimilarImage"
+                            }
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.UploadResponse"
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "404": {
+                        "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/main.UploadResponse"
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "main.Category": {
+        }
+    },
+    "definitions": {
+        "main.AssignmentEvent": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "by": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.BatchUploadResponse": {
+            "type": "object",
+            "properties": {
+                "failed": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.BatchUploadResult"
+                    }
+                },
+                "statusCode": {
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "boolean"
+                },
+                "uploaded": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.BatchUploadResult": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/main.UploadData"
+                },
+                "error_code": {
+                    "type": "string"
+                },
+                "file_name": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "statusCode": {
+                    "type": "integer"
+                },
+                "success": {
+                    "type": "boolean"
+                },
+                "warning": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.Category": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "workflow": {
+                    "$ref": "#/definitions/main.Workflow"
+                }
+            }
+        },
+        "main.CategoryWithTasksResponse": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "tag_counts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.TagCount"
+                    }
+                },
+                "tasks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.TaskResponse"
+                    }
+                }
+            }
+        },
+        "main.ChangeFeed": {
+            "type": "object",
+            "properties": {
+                "changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.TaskChange"
+                    }
+                },
+                "latest": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ChecklistProgress": {
+            "type": "object",
+            "properties": {
+                "done": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ChecklistState": {
+            "type": "object",
+            "properties": {
+                "done": {
+                    "type": "boolean"
+                },
+                "done_at": {
+                    "type": "string"
+                },
+                "done_by": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.GCEntry": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "file": {
+                    "type": "string"
+                },
+                "mod_time": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.GCReport": {
+            "type": "object",
+            "properties": {
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "grace": {
+                    "type": "string"
+                },
+                "kept_recent": {
+                    "type": "integer"
+                },
+                "reclaimed_bytes": {
+                    "type": "integer"
+                },
+                "removed": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.GCEntry"
+                    }
+                },
+                "removed_records": {
+                    "type": "integer"
+                },
+                "scanned_files": {
+                    "type": "integer"
+                },
+                "skipped_foreign": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.SimilarImage": {
+            "type": "object",
+            "properties": {
+                "distance": {
+                    "type": "integer"
+                },
+                "task_item_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "upload": {
+                    "$ref": "#/definitions/main.Upload"
+                }
+            }
+        },
+        "main.StatusTransition": {
             "type": "object",
             "properties": {
-                "data": {
+                "by": {
                     "type": "string"
                 },
-                "id": {
+                "from": {
+                    "type": "string"
+                },
+                "time": {
+                    "type": "string"
+                },
+                "to": {
                     "type": "string"
                 }
             }
         },
-        "main.CategoryWithTasksResponse": {
+        "main.Tag": {
             "type": "object",
             "properties": {
-                "data": {
+                "color": {
                     "type": "string"
                 },
                 "id": {
                     "type": "string"
                 },
-                "tasks": {
-                    "type": "array",
-                    "items": {
-                        "$ref": "#/definitions/main.TaskResponse"
-                    }
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TagCount": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "tag": {
+                    "$ref": "#/definitions/main.Tag"
+                }
+            }
+        },
+        "main.TaskChange": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "priority": {
+                    "type": "string"
+                },
+                "seq": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "task_id": {
+                    "type": "string"
+                },
+                "urgent": {
+                    "type": "boolean"
                 }
             }
         },
@@ -1092,12 +3443,28 @@ const docTemplate = `{
                 "data": {
                     "type": "string"
                 },
+                "done": {
+                    "type": "boolean"
+                },
+                "done_at": {
+                    "type": "string"
+                },
+                "done_by": {
+                    "type": "string"
+                },
                 "id": {
                     "type": "string"
                 },
                 "position": {
                     "type": "integer"
                 },
+                "similar": {
+                    "description": "Similar and Warning flag near-duplicate images when files are\nattached; responses only",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.SimilarImage"
+                    }
+                },
                 "task_id": {
                     "type": "string"
                 },
@@ -1106,12 +3473,29 @@ const docTemplate = `{
                 },
                 "type": {
                     "type": "string"
+                },
+                "upload": {
+                    "description": "Upload is filled in on responses only, never stored",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Upload"
+                        }
+                    ]
+                },
+                "upload_id": {
+                    "type": "string"
+                },
+                "warning": {
+                    "type": "string"
                 }
             }
         },
         "main.TaskItemResponse": {
             "type": "object",
             "properties": {
+                "checklist": {
+                    "$ref": "#/definitions/main.ChecklistState"
+                },
                 "data": {
                     "type": "object",
                     "properties": {
@@ -1134,12 +3518,24 @@ const docTemplate = `{
                 },
                 "type": {
                     "type": "string"
+                },
+                "upload": {
+                    "$ref": "#/definitions/main.Upload"
                 }
             }
         },
         "main.TaskResponse": {
             "type": "object",
             "properties": {
+                "assignees": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "auto_complete": {
+                    "type": "boolean"
+                },
                 "category": {
                     "type": "array",
                     "items": {
@@ -1149,9 +3545,15 @@ const docTemplate = `{
                 "category_id": {
                     "type": "string"
                 },
+                "checklist": {
+                    "$ref": "#/definitions/main.ChecklistProgress"
+                },
                 "deleted_at": {
                     "type": "string"
                 },
+                "due_at": {
+                    "type": "string"
+                },
                 "id": {
                     "type": "string"
                 },
@@ -1161,12 +3563,33 @@ const docTemplate = `{
                 "name": {
                     "type": "string"
                 },
+                "pinned": {
+                    "type": "boolean"
+                },
                 "position": {
                     "type": "integer"
                 },
                 "price": {
                     "type": "number"
                 },
+                "priority": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "status_history": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.StatusTransition"
+                    }
+                },
+                "tags": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.Tag"
+                    }
+                },
                 "task_name": {
                     "type": "array",
                     "items": {
@@ -1175,26 +3598,143 @@ const docTemplate = `{
                 }
             }
         },
+        "main.Upload": {
+            "type": "object",
+            "properties": {
+                "captured_at": {
+                    "type": "string"
+                },
+                "category_id": {
+                    "type": "string"
+                },
+                "codec": {
+                    "type": "string"
+                },
+                "content_type": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "duration_ms": {
+                    "type": "integer"
+                },
+                "file_name": {
+                    "type": "string"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "height": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "owner_id": {
+                    "type": "string"
+                },
+                "phash": {
+                    "type": "string"
+                },
+                "poster": {
+                    "type": "string"
+                },
+                "preview": {
+                    "type": "string"
+                },
+                "ref_count": {
+                    "type": "integer"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "unreferenced_at": {
+                    "description": "UnreferencedAt is when the last task item let go of the upload, or\nwhen it was handed out again as a duplicate while unattached; the\ngarbage collector keeps it for the grace period from then",
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                },
+                "waveform": {
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "width": {
+                    "type": "integer"
+                }
+            }
+        },
         "main.UploadData": {
             "type": "object",
             "properties": {
+                "captured_at": {
+                    "type": "string"
+                },
+                "codec": {
+                    "type": "string"
+                },
                 "content_type": {
                     "type": "string"
                 },
+                "duplicate": {
+                    "type": "boolean"
+                },
                 "duration_ms": {
                     "type": "integer"
                 },
                 "file_name": {
                     "type": "string"
                 },
+                "hash": {
+                    "type": "string"
+                },
+                "height": {
+                    "type": "integer"
+                },
                 "id": {
                     "type": "string"
                 },
+                "phash": {
+                    "type": "string"
+                },
+                "poster": {
+                    "type": "string"
+                },
+                "preview": {
+                    "type": "string"
+                },
+                "signed_url": {
+                    "type": "string"
+                },
+                "similar": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.SimilarImage"
+                    }
+                },
                 "size": {
                     "type": "integer"
                 },
                 "url": {
                     "type": "string"
+                },
+                "variants": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "waveform": {
+                    "type": "array",
+                    "items": {
+                        "type": "number"
+                    }
+                },
+                "width": {
+                    "type": "integer"
                 }
             }
         },
@@ -1204,6 +3744,9 @@ const docTemplate = `{
                 "data": {
                     "$ref": "#/definitions/main.UploadData"
                 },
+                "error_code": {
+                    "type": "string"
+                },
                 "message": {
                     "type": "string"
                 },
@@ -1212,6 +3755,110 @@ const docTemplate = `{
                 },
                 "success": {
                     "type": "boolean"
+                },
+                "warning": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.UploadSession": {
+            "type": "object",
+            "properties": {
+                "category_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "file_name": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "offset": {
+                    "type": "integer"
+                },
+                "owner_id": {
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.UsageEntry": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "files": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "quota_bytes": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.UsageReport": {
+            "type": "object",
+            "properties": {
+                "categories": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.UsageEntry"
+                    }
+                },
+                "total_bytes": {
+                    "type": "integer"
+                },
+                "total_files": {
+                    "type": "integer"
+                },
+                "users": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.UsageEntry"
+                    }
+                }
+            }
+        },
+        "main.Workflow": {
+            "type": "object",
+            "properties": {
+                "initial": {
+                    "type": "string"
+                },
+                "states": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "success": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "transitions": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        }
+                    }
                 }
             }
         }
@@ -1,24 +1,48 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
+	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/bits"
+	"mime"
 	"mime/multipart"
+	"net/http"
 	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/adrium/goheif"
+	"github.com/gabriel-vasile/mimetype"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/nfnt/resize"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -47,36 +71,156 @@ import (
 
 // Models
 type Category struct {
-	ID   string `json:"id"`
-	Data string `json:"data"`
+	ID       string    `json:"id"`
+	Data     string    `json:"data"`
+	Workflow *Workflow `json:"workflow,omitempty"`
+}
+
+// Workflow is the state machine tasks of a category move through.
+// Categories without a workflow use defaultWorkflow.
+type Workflow struct {
+	States      []string            `json:"states"`
+	Initial     string              `json:"initial"`
+	Success     []string            `json:"success"`
+	Transitions map[string][]string `json:"transitions"`
 }
 
 type Task struct {
-	ID         string     `json:"id"`
-	CategoryID string     `json:"category_id"`
-	Name       string     `json:"name"`
-	IsSuccess  bool       `json:"is_success"`
-	Price      *float32   `json:"price"`
-	Position   int        `json:"position"`
-	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
-	Category   *Category  `json:"category,omitempty"`
-	Items      []TaskItem `json:"items,omitempty"`
+	ID                string             `json:"id"`
+	CategoryID        string             `json:"category_id"`
+	Name              string             `json:"name"`
+	IsSuccess         bool               `json:"is_success"`
+	Status            string             `json:"status"`
+	StatusHistory     []StatusTransition `json:"status_history,omitempty"`
+	Price             *float32           `json:"price"`
+	Position          int                `json:"position"`
+	Priority          string             `json:"priority"`
+	DueAt             *time.Time         `json:"due_at,omitempty"`
+	Pinned            bool               `json:"pinned"`
+	TagIDs            []string           `json:"tag_ids"`
+	AutoComplete      bool               `json:"auto_complete"`
+	Assignees         []string           `json:"assignees"`
+	AssignmentHistory []AssignmentEvent  `json:"assignment_history,omitempty"`
+	DeletedAt         *time.Time         `json:"deleted_at,omitempty"`
+	Category          *Category          `json:"category,omitempty"`
+	Items             []TaskItem         `json:"items,omitempty"`
+}
+
+// StatusTransition records a single workflow move of a task
+type StatusTransition struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	By   string    `json:"by,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// AssignmentEvent records a single assign/unassign action on a task
+type AssignmentEvent struct {
+	UserID string    `json:"user_id"`
+	Action string    `json:"action"`
+	By     string    `json:"by,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Tag is a free-form colored label; tasks reference tags by ID
+type Tag struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
 }
 
 type TaskItem struct {
-	ID       string    `json:"id"`
-	TaskID   string    `json:"task_id"`
-	Type     string    `json:"type"`
-	Data     string    `json:"data"`
-	Time     time.Time `json:"time"`
-	Position int       `json:"position"`
+	ID       string     `json:"id"`
+	TaskID   string     `json:"task_id"`
+	Type     string     `json:"type"`
+	Data     string     `json:"data"`
+	Time     time.Time  `json:"time"`
+	Position int        `json:"position"`
+	Done     bool       `json:"done"`
+	DoneBy   string     `json:"done_by,omitempty"`
+	DoneAt   *time.Time `json:"done_at,omitempty"`
+	UploadID string     `json:"upload_id,omitempty"`
+
+	// Upload is filled in on responses only, never stored
+	Upload *Upload `json:"upload,omitempty"`
+	// Similar and Warning flag near-duplicate images when files are
+	// attached; responses only
+	Similar []SimilarImage `json:"similar,omitempty"`
+	Warning string         `json:"warning,omitempty"`
 }
 
+// checklistItemType is the TaskItem type that can be ticked off
+const checklistItemType = "checklist"
+
 // Database structure
 type Database struct {
 	Categories []Category `json:"categories"`
 	Tasks      []Task     `json:"tasks"`
 	TaskItems  []TaskItem `json:"task_items"`
+	Tags       []Tag      `json:"tags"`
+	Uploads    []Upload   `json:"uploads"`
+
+	UploadSessions []UploadSession `json:"upload_sessions"`
+}
+
+// UploadSession is a resumable upload in progress. Chunks are appended to
+// uploads_tmp/<id>.part until Offset reaches Size.
+type UploadSession struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	FileName   string    `json:"file_name"`
+	Size       int64     `json:"size"`
+	Offset     int64     `json:"offset"`
+	OwnerID    string    `json:"owner_id,omitempty"`
+	CategoryID string    `json:"category_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Upload records a stored file, who it counts against for quotas and the
+// metadata read from it. Files are named by the SHA-256 of their content, so
+// identical uploads share one Upload; RefCount is the number of TaskItems
+// pointing to it through UploadID.
+type Upload struct {
+	ID          string     `json:"id"`
+	OwnerID     string     `json:"owner_id,omitempty"`
+	CategoryID  string     `json:"category_id,omitempty"`
+	URL         string     `json:"url"`
+	FileName    string     `json:"file_name"`
+	ContentType string     `json:"content_type"`
+	Size        int64      `json:"size"`
+	Hash        string     `json:"hash"`
+	RefCount    int        `json:"ref_count"`
+	DurationMs  *int       `json:"duration_ms,omitempty"`
+	Width       int        `json:"width,omitempty"`
+	Height      int        `json:"height,omitempty"`
+	Codec       string     `json:"codec,omitempty"`
+	Poster      string     `json:"poster,omitempty"`
+	Preview     string     `json:"preview,omitempty"`
+	Waveform    []float64  `json:"waveform,omitempty"`
+	PHash       string     `json:"phash,omitempty"`
+	CapturedAt  *time.Time `json:"captured_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	// UnreferencedAt is when the last task item let go of the upload, or
+	// when it was handed out again as a duplicate while unattached; the
+	// garbage collector keeps it for the grace period from then
+	UnreferencedAt *time.Time `json:"unreferenced_at,omitempty"`
+}
+
+// SimilarImage is an image upload close to another one by perceptual hash.
+// TaskItemIDs are the items in the searched scope that show it.
+type SimilarImage struct {
+	Upload      Upload   `json:"upload"`
+	Distance    int      `json:"distance"`
+	TaskItemIDs []string `json:"task_item_ids"`
+}
+
+// mediaInfo is the metadata read from an audio or video file's headers
+type mediaInfo struct {
+	DurationMs *int
+	Width      int
+	Height     int
+	Codec      string
 }
 
 // Response structures
@@ -89,42 +233,270 @@ type TaskItemResponse struct {
 		Data string    `json:"data"`
 		Time time.Time `json:"time"`
 	} `json:"data"`
+	Checklist *ChecklistState `json:"checklist,omitempty"`
+	Upload    *Upload         `json:"upload,omitempty"`
+}
+
+type ChecklistState struct {
+	Done   bool       `json:"done"`
+	DoneBy string     `json:"done_by,omitempty"`
+	DoneAt *time.Time `json:"done_at,omitempty"`
+}
+
+type ChecklistProgress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
 }
 
 type TaskResponse struct {
-	ID         string             `json:"id"`
-	CategoryID string             `json:"category_id"`
-	Name       string             `json:"name"`
-	IsSuccess  bool               `json:"is_success"`
-	Price      *float32           `json:"price"`
-	Position   int                `json:"position"`
-	DeletedAt  *time.Time         `json:"deleted_at,omitempty"`
-	Category   []Category         `json:"category"`
-	TaskName   []TaskItemResponse `json:"task_name"`
+	ID            string             `json:"id"`
+	CategoryID    string             `json:"category_id"`
+	Name          string             `json:"name"`
+	IsSuccess     bool               `json:"is_success"`
+	Status        string             `json:"status"`
+	StatusHistory []StatusTransition `json:"status_history"`
+	Price         *float32           `json:"price"`
+	Position      int                `json:"position"`
+	Priority      string             `json:"priority"`
+	DueAt         *time.Time         `json:"due_at,omitempty"`
+	Pinned        bool               `json:"pinned"`
+	Tags          []Tag              `json:"tags"`
+	AutoComplete  bool               `json:"auto_complete"`
+	Checklist     *ChecklistProgress `json:"checklist,omitempty"`
+	Assignees     []string           `json:"assignees"`
+	DeletedAt     *time.Time         `json:"deleted_at,omitempty"`
+	Category      []Category         `json:"category"`
+	TaskName      []TaskItemResponse `json:"task_name"`
 }
 
 type CategoryWithTasksResponse struct {
-	ID    string         `json:"id"`
-	Data  string         `json:"data"`
-	Tasks []TaskResponse `json:"tasks"`
+	ID        string         `json:"id"`
+	Data      string         `json:"data"`
+	TagCounts []TagCount     `json:"tag_counts"`
+	Tasks     []TaskResponse `json:"tasks"`
+}
+
+type TagCount struct {
+	Tag   Tag `json:"tag"`
+	Count int `json:"count"`
 }
 
 type UploadData struct {
-	ID          string `json:"id"`
-	Size        int64  `json:"size"`
-	URL         string `json:"url"`
-	FileName    string `json:"file_name"`
-	ContentType string `json:"content_type"`
-	DurationMs  *int   `json:"duration_ms"`
+	ID          string            `json:"id"`
+	Size        int64             `json:"size"`
+	URL         string            `json:"url"`
+	FileName    string            `json:"file_name"`
+	ContentType string            `json:"content_type"`
+	Hash        string            `json:"hash,omitempty"`
+	SignedURL   string            `json:"signed_url,omitempty"`
+	Duplicate   bool              `json:"duplicate,omitempty"`
+	DurationMs  *int              `json:"duration_ms"`
+	Width       int               `json:"width,omitempty"`
+	Height      int               `json:"height,omitempty"`
+	Codec       string            `json:"codec,omitempty"`
+	Poster      string            `json:"poster,omitempty"`
+	Preview     string            `json:"preview,omitempty"`
+	Waveform    []float64         `json:"waveform,omitempty"`
+	PHash       string            `json:"phash,omitempty"`
+	Variants    map[string]string `json:"variants,omitempty"`
+	CapturedAt  *time.Time        `json:"captured_at,omitempty"`
+	Similar     []SimilarImage    `json:"similar,omitempty"`
+}
+
+// imageVariant is a resized copy generated for every uploaded image
+type imageVariant struct {
+	Name  string
+	Width uint
 }
 
 type UploadResponse struct {
 	Success    bool       `json:"success"`
 	StatusCode int        `json:"statusCode"`
+	ErrorCode  string     `json:"error_code,omitempty"`
 	Message    string     `json:"message"`
+	Warning    string     `json:"warning,omitempty"`
 	Data       UploadData `json:"data"`
 }
 
+// Upload error codes returned in UploadResponse.ErrorCode
+const (
+	errCodeMissingFile     = "missing_file"
+	errCodeUnsupportedType = "unsupported_type"
+	errCodeTypeMismatch    = "type_mismatch"
+	errCodeExecutable      = "executable_content"
+	errCodeDecodeFailed    = "decode_failed"
+	errCodeStorage         = "storage_error"
+	errCodeTooLarge        = "file_too_large"
+	errCodeQuotaExceeded   = "quota_exceeded"
+	errCodeTooMany         = "too_many_files"
+	errCodeInternal        = "internal_error"
+)
+
+// uploadLimits are the per-endpoint maximum request sizes and the storage
+// quotas in bytes (0 = unlimited). See loadUploadLimits for the env names.
+var uploadLimits = struct {
+	Image         int64
+	Audio         int64
+	Video         int64
+	UserQuota     int64
+	CategoryQuota int64
+}{
+	Image: 25 << 20,
+	Audio: 50 << 20,
+	Video: 500 << 20,
+}
+
+// uploadBatchLimits bound a batch upload: how many files one request may
+// carry (UPLOAD_BATCH_MAX_FILES) and how many are processed at once
+// (UPLOAD_BATCH_WORKERS)
+var uploadBatchLimits = struct {
+	MaxFiles int
+	Workers  int
+}{
+	MaxFiles: 20,
+	Workers:  4,
+}
+
+// uploadSessionTTL is how long an idle resumable upload is kept
+// (UPLOAD_SESSION_TTL, e.g. "24h"). Every chunk extends it.
+var uploadSessionTTL = 24 * time.Hour
+
+// mediaSigning controls access to uploaded files. By default /static is
+// public, as before signed links existed. With MEDIA_PUBLIC_STATIC=false
+// /static is closed and responses carry HMAC-signed /media links
+// (MEDIA_SIGNING_KEY) that expire after TTL (MEDIA_URL_TTL).
+var mediaSigning = struct {
+	Key          []byte
+	TTL          time.Duration
+	PublicStatic bool
+}{
+	TTL: time.Hour,
+}
+
+// imageStorageFormat is how uploaded images are stored (IMAGE_STORAGE_FORMAT):
+//   - original: keep the uploaded format; when no rotation or resize is
+//     needed the uploaded bytes are kept with their metadata removed
+//   - jpeg: convert everything to JPEG
+//   - webp-lossless: convert to lossless WebP
+//   - webp-lossy: near-lossless WebP, pixels are quantized before the
+//     lossless encoder. There is no pure-Go lossy (VP8) encoder.
+//
+// Both WebP modes beat PNG on screenshots and graphics; photos come out
+// several times larger than JPEG.
+var imageStorageFormat = "original"
+
+var imageStorageFormats = []string{"original", "jpeg", "webp-lossless", "webp-lossy"}
+
+// similarImageDistance is the largest perceptual hash distance, in bits
+// of 64, at which two images count as near-duplicates
+// (IMAGE_SIMILAR_DISTANCE)
+var similarImageDistance = 10
+
+// uploadGC schedules the orphaned upload collection (UPLOAD_GC_INTERVAL,
+// off when empty). Files younger than Grace (UPLOAD_GC_GRACE) are kept so
+// an upload can still be attached to a task item.
+var uploadGC = struct {
+	Interval time.Duration
+	Grace    time.Duration
+}{
+	Grace: 24 * time.Hour,
+}
+
+// sessionLocks serialises chunk writes per upload session. An entry only
+// exists while its session does: removeUploadSession drops it.
+var (
+	sessionLocks   = map[string]*sync.Mutex{}
+	sessionLocksMu sync.Mutex
+)
+
+type UsageEntry struct {
+	ID         string `json:"id"`
+	Bytes      int64  `json:"bytes"`
+	Files      int    `json:"files"`
+	QuotaBytes int64  `json:"quota_bytes"`
+}
+
+type UsageReport struct {
+	TotalBytes int64        `json:"total_bytes"`
+	TotalFiles int          `json:"total_files"`
+	Users      []UsageEntry `json:"users"`
+	Categories []UsageEntry `json:"categories"`
+}
+
+// BatchUploadResponse reports every file of a batch upload. Success is
+// set only when all files were stored.
+type BatchUploadResponse struct {
+	Success    bool                `json:"success"`
+	StatusCode int                 `json:"statusCode"`
+	Message    string              `json:"message"`
+	Uploaded   int                 `json:"uploaded"`
+	Failed     int                 `json:"failed"`
+	Results    []BatchUploadResult `json:"results"`
+}
+
+type BatchUploadResult struct {
+	FileName   string      `json:"file_name"`
+	Kind       string      `json:"kind,omitempty"`
+	Success    bool        `json:"success"`
+	StatusCode int         `json:"statusCode"`
+	ErrorCode  string      `json:"error_code,omitempty"`
+	Message    string      `json:"message"`
+	Warning    string      `json:"warning,omitempty"`
+	Data       *UploadData `json:"data,omitempty"`
+}
+
+// GCReport lists the files an orphaned upload collection removed, or would
+// remove in a dry run
+type GCReport struct {
+	DryRun         bool      `json:"dry_run"`
+	Grace          string    `json:"grace"`
+	ScannedFiles   int       `json:"scanned_files"`
+	KeptRecent     int       `json:"kept_recent"`
+	SkippedForeign int       `json:"skipped_foreign"`
+	Removed        []GCEntry `json:"removed"`
+	RemovedRecords int       `json:"removed_records"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+}
+
+type GCEntry struct {
+	File    string    `json:"file"`
+	Bytes   int64     `json:"bytes"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// uploadFormat is a content type accepted by an upload endpoint
+type uploadFormat struct {
+	MIME        string   // type as detected from magic bytes
+	Ext         string   // extension the file is stored with
+	ContentType string   // Content-Type reported to clients
+	Extensions  []string // client file extensions allowed for this content
+}
+
+func (f uploadFormat) acceptsExtension(ext string) bool {
+	for _, e := range f.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadPolicy is the allow-list of an upload endpoint. With StrictExtension
+// the client's file extension must match the detected content.
+type uploadPolicy struct {
+	Formats         []uploadFormat
+	StrictExtension bool
+}
+
+func (p uploadPolicy) find(detected *mimetype.MIME) *uploadFormat {
+	for i := range p.Formats {
+		if detected.Is(p.Formats[i].MIME) {
+			return &p.Formats[i]
+		}
+	}
+	return nil
+}
+
 // Global variables
 var (
 	db       Database
@@ -132,6 +504,126 @@ var (
 	dataFile = "database.json"
 )
 
+// TaskChange is an entry of the change feed that screens such as the
+// kitchen display poll. Urgent tasks are flagged so they can be highlighted.
+type TaskChange struct {
+	Seq      int64     `json:"seq"`
+	TaskID   string    `json:"task_id"`
+	Action   string    `json:"action"`
+	Name     string    `json:"name"`
+	Status   string    `json:"status"`
+	Priority string    `json:"priority"`
+	Urgent   bool      `json:"urgent"`
+	At       time.Time `json:"at"`
+}
+
+type ChangeFeed struct {
+	Latest  int64        `json:"latest"`
+	Changes []TaskChange `json:"changes"`
+}
+
+// taskChanges keeps the latest maxTaskChanges changes in memory, guarded by
+// dbMutex. Sequence numbers restart with the server.
+var taskChanges struct {
+	seq     int64
+	entries []TaskChange
+}
+
+const maxTaskChanges = 1000
+
+// Task priorities, most urgent first
+var priorityRank = map[string]int{
+	"urgent": 0,
+	"high":   1,
+	"normal": 2,
+	"low":    3,
+}
+
+// Images are decoded and re-encoded, so a wrong extension is harmless there
+var imageUploadPolicy = uploadPolicy{
+	Formats: []uploadFormat{
+		{"image/jpeg", ".jpg", "image/jpeg", []string{".jpg", ".jpeg", ".jfif"}},
+		{"image/png", ".png", "image/png", []string{".png"}},
+		{"image/gif", ".gif", "image/gif", []string{".gif"}},
+		{"image/bmp", ".bmp", "image/bmp", []string{".bmp"}},
+		{"image/tiff", ".tiff", "image/tiff", []string{".tiff", ".tif"}},
+		{"image/webp", ".webp", "image/webp", []string{".webp"}},
+		{"image/heic", ".heic", "image/heic", []string{".heic", ".heif"}},
+		{"image/heic-sequence", ".heic", "image/heic", []string{".heic", ".heif"}},
+		{"image/heif", ".heif", "image/heif", []string{".heic", ".heif"}},
+		{"image/heif-sequence", ".heif", "image/heif", []string{".heic", ".heif"}},
+	},
+}
+
+var audioUploadPolicy = uploadPolicy{
+	Formats: []uploadFormat{
+		{"audio/mpeg", ".mp3", "audio/mpeg", []string{".mp3"}},
+		{"audio/wav", ".wav", "audio/wav", []string{".wav", ".wave"}},
+		{"audio/ogg", ".ogg", "audio/ogg", []string{".ogg", ".oga", ".opus"}},
+		{"audio/x-m4a", ".m4a", "audio/mp4", []string{".m4a", ".mp4", ".aac"}},
+		{"audio/mp4", ".m4a", "audio/mp4", []string{".m4a", ".mp4", ".aac"}},
+		{"video/mp4", ".m4a", "audio/mp4", []string{".m4a"}},
+		{"audio/aac", ".aac", "audio/aac", []string{".aac"}},
+		{"audio/flac", ".flac", "audio/flac", []string{".flac"}},
+		{"audio/amr", ".amr", "audio/amr", []string{".amr"}},
+		{"video/webm", ".webm", "audio/webm", []string{".webm", ".weba"}},
+		{"video/x-ms-asf", ".wma", "audio/x-ms-wma", []string{".wma"}},
+	},
+	StrictExtension: true,
+}
+
+var videoUploadPolicy = uploadPolicy{
+	Formats: []uploadFormat{
+		{"video/mp4", ".mp4", "video/mp4", []string{".mp4"}},
+		{"video/quicktime", ".mov", "video/quicktime", []string{".mov", ".qt"}},
+		{"video/x-m4v", ".m4v", "video/x-m4v", []string{".m4v", ".mp4"}},
+		{"video/3gpp", ".3gp", "video/3gpp", []string{".3gp", ".mp4"}},
+		{"video/webm", ".webm", "video/webm", []string{".webm"}},
+		{"video/x-matroska", ".mkv", "video/x-matroska", []string{".mkv"}},
+		{"video/x-msvideo", ".avi", "video/x-msvideo", []string{".avi"}},
+		{"video/x-flv", ".flv", "video/x-flv", []string{".flv"}},
+		{"video/x-ms-asf", ".wmv", "video/x-ms-wmv", []string{".wmv", ".asf"}},
+	},
+	StrictExtension: true,
+}
+
+// executableMIMEs are rejected on every endpoint: binaries, scripts and
+// markup a browser would run if served from /static
+var executableMIMEs = []string{
+	"application/vnd.microsoft.portable-executable",
+	"application/x-elf",
+	"application/x-mach-binary",
+	"application/java-archive",
+	"text/x-shellscript",
+	"text/x-php",
+	"text/html",
+	"application/xhtml+xml",
+	"image/svg+xml",
+}
+
+// imageVariants are ordered by width. The largest one is the stored file
+// itself; the others are saved next to it as <id>_<name><ext>.
+// Overridable with IMAGE_VARIANTS, e.g. "thumb:160,medium:640,full:2048".
+var imageVariants = []imageVariant{
+	{Name: "thumb", Width: 160},
+	{Name: "medium", Width: 640},
+	{Name: "full", Width: 2048},
+}
+
+// defaultWorkflow is the bakery order process: new → baking → ready →
+// out for delivery → delivered, with cancellation allowed until delivery
+var defaultWorkflow = Workflow{
+	States:  []string{"new", "baking", "ready", "out_for_delivery", "delivered", "cancelled"},
+	Initial: "new",
+	Success: []string{"delivered"},
+	Transitions: map[string][]string{
+		"new":              {"baking", "cancelled"},
+		"baking":           {"ready", "cancelled"},
+		"ready":            {"out_for_delivery", "cancelled"},
+		"out_for_delivery": {"delivered", "cancelled"},
+	},
+}
+
 // CORS Middleware
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -145,9 +637,9 @@ func CORSMiddleware() gin.HandlerFunc {
 		}
 
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Accept, Origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE, PATCH")
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Accept, Origin, Cache-Control, X-Requested-With, X-User-ID, Upload-Offset, Upload-Length")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, HEAD, PUT, DELETE, PATCH")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type, Location, Upload-Offset, Upload-Length, Upload-Expires")
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
 
 		if c.Request.Method == "OPTIONS" {
@@ -171,13 +663,149 @@ func loadDatabase() error {
 				Categories: []Category{},
 				Tasks:      []Task{},
 				TaskItems:  []TaskItem{},
+				Tags:       []Tag{},
+				Uploads:    []Upload{},
+
+				UploadSessions: []UploadSession{},
 			}
 			return saveDatabase()
 		}
 		return err
 	}
 
-	return json.Unmarshal(data, &db)
+	if err := json.Unmarshal(data, &db); err != nil {
+		return err
+	}
+
+	migrateTasks()
+	if migrateUploads() {
+		return saveDatabase()
+	}
+	return nil
+}
+
+// migrateTasks fills fields added after tasks were first saved: a workflow
+// status derived from is_success, and the default priority
+func migrateTasks() {
+	for i := range db.Tasks {
+		task := &db.Tasks[i]
+		if task.Status == "" {
+			wf := workflowForCategory(task.CategoryID)
+			if task.IsSuccess {
+				task.Status = wf.Success[0]
+			} else {
+				task.Status = wf.Initial
+			}
+		}
+		if task.Priority == "" {
+			task.Priority = "normal"
+		}
+	}
+}
+
+// migrateUploads links task items to their upload records. Files that were
+// uploaded before uploads were recorded get a record built from the file on
+// disk, records without a hash get one, and reference counts are recomputed.
+// Reports whether anything changed.
+func migrateUploads() bool {
+	changed := false
+
+	for i := range db.Uploads {
+		if db.Uploads[i].Hash == "" {
+			name, _ := blobNameFromURL(db.Uploads[i].URL)
+			if hash, err := hashBlob(name); err == nil {
+				db.Uploads[i].Hash = hash
+				changed = true
+			}
+		}
+	}
+
+	for i := range db.TaskItems {
+		item := &db.TaskItems[i]
+		if _, ok := blobNameFromURL(item.Data); item.UploadID != "" || !ok {
+			continue
+		}
+
+		upload := findUploadByURL(item.Data)
+		if upload == nil {
+			legacy, err := legacyUpload(item.Data)
+			if err != nil {
+				continue
+			}
+			db.Uploads = append(db.Uploads, legacy)
+			upload = &db.Uploads[len(db.Uploads)-1]
+		}
+		item.UploadID = upload.ID
+		changed = true
+	}
+
+	refs := map[string]int{}
+	for _, item := range db.TaskItems {
+		if item.UploadID != "" {
+			refs[item.UploadID]++
+		}
+	}
+	for i := range db.Uploads {
+		if db.Uploads[i].RefCount != refs[db.Uploads[i].ID] {
+			db.Uploads[i].RefCount = refs[db.Uploads[i].ID]
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// legacyUpload builds an upload record for a file already in the blob store
+func legacyUpload(url string) (Upload, error) {
+	fileName, _ := blobNameFromURL(url)
+
+	info, err := blobStore.Stat(fileName)
+	if err != nil {
+		return Upload{}, err
+	}
+	hash, err := hashBlob(fileName)
+	if err != nil {
+		return Upload{}, err
+	}
+
+	ext := filepath.Ext(fileName)
+	id := strings.TrimSuffix(fileName, ext)
+	if _, err := uuid.Parse(id); err != nil || findUploadByID(id) != nil {
+		id = uuid.New().String()
+	}
+
+	upload := Upload{
+		ID:        id,
+		URL:       url,
+		FileName:  fileName,
+		Size:      info.Size,
+		Hash:      hash,
+		CreatedAt: info.ModTime,
+	}
+
+	f, err := blobStore.Open(fileName)
+	if err != nil {
+		return Upload{}, err
+	}
+	defer f.Close()
+
+	if detected, err := mimetype.DetectReader(f); err == nil {
+		upload.ContentType = detected.String()
+	}
+	f.Seek(0, io.SeekStart)
+
+	if strings.HasPrefix(upload.ContentType, "image/") {
+		if config, _, err := image.DecodeConfig(f); err == nil {
+			upload.Width, upload.Height = config.Width, config.Height
+		}
+	} else {
+		media := probeMedia(f, info.Size, strings.ToLower(ext))
+		upload.DurationMs = media.DurationMs
+		upload.Width, upload.Height = media.Width, media.Height
+		upload.Codec = media.Codec
+	}
+
+	return upload, nil
 }
 
 func saveDatabase() error {
@@ -218,6 +846,85 @@ func findTaskByID(id string, includeDeleted bool) *Task {
 	return nil
 }
 
+func findTagByID(id string) *Tag {
+	for i := range db.Tags {
+		if db.Tags[i].ID == id {
+			return &db.Tags[i]
+		}
+	}
+	return nil
+}
+
+func findTagByName(name string) *Tag {
+	for i := range db.Tags {
+		if strings.EqualFold(db.Tags[i].Name, name) {
+			return &db.Tags[i]
+		}
+	}
+	return nil
+}
+
+func hasTag(task Task, tagID string) bool {
+	for _, id := range task.TagIDs {
+		if id == tagID {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesTags reports whether a task carries any (or, with matchAll, every)
+// of the given tags. An empty filter matches every task.
+func matchesTags(task Task, tagIDs []string, matchAll bool) bool {
+	if len(tagIDs) == 0 {
+		return true
+	}
+	for _, tagID := range tagIDs {
+		if hasTag(task, tagID) {
+			if !matchAll {
+				return true
+			}
+		} else if matchAll {
+			return false
+		}
+	}
+	return matchAll
+}
+
+// tagCountsForTasks counts how many of the given tasks carry each tag,
+// ordered by tag name
+func tagCountsForTasks(tasks []Task) []TagCount {
+	counts := map[string]int{}
+	for _, task := range tasks {
+		for _, tagID := range task.TagIDs {
+			counts[tagID]++
+		}
+	}
+
+	result := []TagCount{}
+	for _, tag := range db.Tags {
+		if n := counts[tag.ID]; n > 0 {
+			result = append(result, TagCount{Tag: tag, Count: n})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.ToLower(result[i].Tag.Name) < strings.ToLower(result[j].Tag.Name)
+	})
+	return result
+}
+
+func validTagColor(color string) bool {
+	if len(color) != 7 || color[0] != '#' {
+		return false
+	}
+	for _, ch := range color[1:] {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", ch) {
+			return false
+		}
+	}
+	return true
+}
+
 func findTaskItemByID(id string) *TaskItem {
 	for i := range db.TaskItems {
 		if db.TaskItems[i].ID == id {
@@ -258,6 +965,36 @@ func getTasksByCategoryID(categoryID string, includeDeleted bool) []Task {
 	return tasks
 }
 
+// sortTasks orders tasks in place. Pinned tasks always come first. With
+// order "priority" the rest are ordered by priority, then due date (tasks
+// without one last), then position; otherwise by position only.
+func sortTasks(tasks []Task, order string) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if a.Pinned != b.Pinned {
+			return a.Pinned
+		}
+		if order == "priority" {
+			ra, rb := priorityRank[a.Priority], priorityRank[b.Priority]
+			if ra != rb {
+				return ra < rb
+			}
+			if (a.DueAt == nil) != (b.DueAt == nil) {
+				return a.DueAt != nil
+			}
+			if a.DueAt != nil && !a.DueAt.Equal(*b.DueAt) {
+				return a.DueAt.Before(*b.DueAt)
+			}
+		}
+		return a.Position < b.Position
+	})
+}
+
+func validPriority(priority string) bool {
+	_, ok := priorityRank[priority]
+	return ok
+}
+
 func deleteCategoryByID(id string) bool {
 	for i := range db.Categories {
 		if db.Categories[i].ID == id {
@@ -288,1132 +1025,6513 @@ func deleteTaskItemByID(id string) bool {
 	return false
 }
 
-func convertToTaskResponse(task Task) TaskResponse {
-	response := TaskResponse{
-		ID:         task.ID,
-		CategoryID: task.CategoryID,
-		Name:       task.Name,
-		IsSuccess:  task.IsSuccess,
-		Price:      task.Price,
-		Position:   task.Position,
-		DeletedAt:  task.DeletedAt,
-		Category:   []Category{},
-		TaskName:   []TaskItemResponse{},
+func workflowForCategory(categoryID string) Workflow {
+	cat := findCategoryByID(categoryID)
+	if cat != nil && cat.Workflow != nil {
+		return *cat.Workflow
 	}
+	return defaultWorkflow
+}
 
-	cat := findCategoryByID(task.CategoryID)
-	if cat != nil {
-		response.Category = append(response.Category, *cat)
+func (w Workflow) hasState(state string) bool {
+	for _, s := range w.States {
+		if s == state {
+			return true
+		}
 	}
+	return false
+}
 
-	items := getTaskItemsByID(task.ID)
-	for _, item := range items {
-		itemResp := TaskItemResponse{
-			ID:       item.ID,
-			Type:     item.Type,
-			Position: item.Position,
+func (w Workflow) isSuccess(state string) bool {
+	for _, s := range w.Success {
+		if s == state {
+			return true
 		}
-		itemResp.Data.ID = item.ID
-		itemResp.Data.Data = item.Data
-		itemResp.Data.Time = item.Time
-		response.TaskName = append(response.TaskName, itemResp)
 	}
-
-	return response
+	return false
 }
 
-func decodeImage(file multipart.File, ext string) (image.Image, string, error) {
-	file.Seek(0, 0)
-
-	if ext == ".heic" || ext == ".heif" {
-		return nil, "", fmt.Errorf("HEIC/HEIF format is not supported. Please convert to JPG/PNG")
+func (w Workflow) allowedFrom(state string) []string {
+	allowed := w.Transitions[state]
+	if allowed == nil {
+		return []string{}
 	}
+	return allowed
+}
 
-	if ext == ".webp" {
-		img, err := webp.Decode(file)
-		if err == nil {
-			return img, "webp", nil
+func (w Workflow) canTransition(from, to string) bool {
+	for _, s := range w.allowedFrom(from) {
+		if s == to {
+			return true
 		}
 	}
+	return false
+}
 
-	if ext == ".bmp" {
-		img, err := bmp.Decode(file)
-		if err == nil {
-			return img, "bmp", nil
+func validateWorkflow(w Workflow) error {
+	if len(w.States) == 0 {
+		return fmt.Errorf("workflow must have at least one state")
+	}
+	seen := map[string]bool{}
+	for _, s := range w.States {
+		if s == "" {
+			return fmt.Errorf("workflow state names must not be empty")
 		}
+		if seen[s] {
+			return fmt.Errorf("duplicate workflow state %q", s)
+		}
+		seen[s] = true
 	}
-
-	if ext == ".tiff" || ext == ".tif" {
-		img, err := tiff.Decode(file)
-		if err == nil {
-			return img, "tiff", nil
+	if !seen[w.Initial] {
+		return fmt.Errorf("initial state %q is not a workflow state", w.Initial)
+	}
+	if len(w.Success) == 0 {
+		return fmt.Errorf("workflow must have at least one success state")
+	}
+	for _, s := range w.Success {
+		if !seen[s] {
+			return fmt.Errorf("success state %q is not a workflow state", s)
 		}
 	}
-
-	file.Seek(0, 0)
-	img, format, err := image.Decode(file)
-	if err != nil {
-		return nil, "", fmt.Errorf("image decode error: %v", err)
+	for from, targets := range w.Transitions {
+		if !seen[from] {
+			return fmt.Errorf("transition source %q is not a workflow state", from)
+		}
+		for _, to := range targets {
+			if !seen[to] {
+				return fmt.Errorf("transition target %q is not a workflow state", to)
+			}
+		}
 	}
-
-	return img, format, nil
+	return nil
 }
 
-func saveImage(img image.Image, savePath string, originalExt string) error {
-	out, err := os.Create(savePath)
-	if err != nil {
-		return fmt.Errorf("file creation error: %v", err)
+// setTaskStatus moves a task to a new status without checking the workflow,
+// records the transition and keeps is_success in sync
+func setTaskStatus(task *Task, status string, by string) {
+	if task.Status == status {
+		return
+	}
+	task.StatusHistory = append(task.StatusHistory, StatusTransition{
+		From: task.Status,
+		To:   status,
+		By:   by,
+		Time: time.Now(),
+	})
+	task.Status = status
+	task.IsSuccess = workflowForCategory(task.CategoryID).isSuccess(status)
+}
+
+// setTaskSuccess maps the legacy is_success flag onto the workflow:
+// true moves the task to the first success state, false back to the initial one
+func setTaskSuccess(task *Task, success bool, by string) {
+	wf := workflowForCategory(task.CategoryID)
+	if success == wf.isSuccess(task.Status) {
+		task.IsSuccess = success
+		return
+	}
+	if success {
+		setTaskStatus(task, wf.Success[0], by)
+	} else {
+		setTaskStatus(task, wf.Initial, by)
+	}
+}
+
+// currentUserID returns the staff member making the request (X-User-ID header)
+func currentUserID(c *gin.Context) string {
+	return strings.TrimSpace(c.GetHeader("X-User-ID"))
+}
+
+func isAssigned(task Task, userID string) bool {
+	for _, id := range task.Assignees {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func assignUser(task *Task, userID string, by string) bool {
+	if isAssigned(*task, userID) {
+		return false
+	}
+	task.Assignees = append(task.Assignees, userID)
+	task.AssignmentHistory = append(task.AssignmentHistory, AssignmentEvent{
+		UserID: userID,
+		Action: "assigned",
+		By:     by,
+		Time:   time.Now(),
+	})
+	return true
+}
+
+func unassignUser(task *Task, userID string, by string) bool {
+	for i, id := range task.Assignees {
+		if id == userID {
+			task.Assignees = append(task.Assignees[:i], task.Assignees[i+1:]...)
+			task.AssignmentHistory = append(task.AssignmentHistory, AssignmentEvent{
+				UserID: userID,
+				Action: "unassigned",
+				By:     by,
+				Time:   time.Now(),
+			})
+			return true
+		}
+	}
+	return false
+}
+
+func convertToTaskResponse(task Task) TaskResponse {
+	response := TaskResponse{
+		ID:            task.ID,
+		CategoryID:    task.CategoryID,
+		Name:          task.Name,
+		IsSuccess:     task.IsSuccess,
+		Status:        task.Status,
+		StatusHistory: []StatusTransition{},
+		Price:         task.Price,
+		Position:      task.Position,
+		Priority:      task.Priority,
+		DueAt:         task.DueAt,
+		Pinned:        task.Pinned,
+		AutoComplete:  task.AutoComplete,
+		Assignees:     []string{},
+		Tags:          []Tag{},
+		DeletedAt:     task.DeletedAt,
+		Category:      []Category{},
+		TaskName:      []TaskItemResponse{},
+	}
+
+	response.Assignees = append(response.Assignees, task.Assignees...)
+	response.StatusHistory = append(response.StatusHistory, task.StatusHistory...)
+	for _, tagID := range task.TagIDs {
+		if tag := findTagByID(tagID); tag != nil {
+			response.Tags = append(response.Tags, *tag)
+		}
+	}
+
+	cat := findCategoryByID(task.CategoryID)
+	if cat != nil {
+		response.Category = append(response.Category, *cat)
+	}
+
+	items := getTaskItemsByID(task.ID)
+	for _, item := range items {
+		itemResp := TaskItemResponse{
+			ID:       item.ID,
+			Type:     item.Type,
+			Position: item.Position,
+		}
+		itemResp.Data.ID = item.ID
+		itemResp.Data.Data = mediaURL(item.Data)
+		itemResp.Data.Time = item.Time
+		if item.Type == checklistItemType {
+			itemResp.Checklist = &ChecklistState{
+				Done:   item.Done,
+				DoneBy: item.DoneBy,
+				DoneAt: item.DoneAt,
+			}
+		}
+		itemResp.Upload = signedUpload(findUploadByID(item.UploadID))
+		response.TaskName = append(response.TaskName, itemResp)
+	}
+
+	if progress := checklistProgress(items); progress.Total > 0 {
+		response.Checklist = &progress
+	}
+
+	return response
+}
+
+func checklistProgress(items []TaskItem) ChecklistProgress {
+	var progress ChecklistProgress
+	for _, item := range items {
+		if item.Type != checklistItemType {
+			continue
+		}
+		progress.Total++
+		if item.Done {
+			progress.Done++
+		}
+	}
+	return progress
+}
+
+// recordTaskChange appends to the change feed; dbMutex must be held
+func recordTaskChange(task Task, action string) {
+	taskChanges.seq++
+	taskChanges.entries = append(taskChanges.entries, TaskChange{
+		Seq:      taskChanges.seq,
+		TaskID:   task.ID,
+		Action:   action,
+		Name:     task.Name,
+		Status:   task.Status,
+		Priority: task.Priority,
+		Urgent:   task.Priority == "urgent" && task.DeletedAt == nil,
+		At:       time.Now(),
+	})
+	if n := len(taskChanges.entries); n > maxTaskChanges {
+		taskChanges.entries = append([]TaskChange(nil), taskChanges.entries[n-maxTaskChanges:]...)
+	}
+}
+
+// autoCompleteTask marks a task successful once every checklist item is done,
+// if the task opted in with auto_complete
+func autoCompleteTask(taskID string, by string) {
+	task := findTaskByID(taskID, false)
+	if task == nil || !task.AutoComplete || task.IsSuccess {
+		return
+	}
+	progress := checklistProgress(getTaskItemsByID(taskID))
+	if progress.Total > 0 && progress.Done == progress.Total {
+		setTaskSuccess(task, true, by)
+		recordTaskChange(*task, "status")
+	}
+}
+
+func decodeImage(file multipart.File, ext string) (image.Image, string, error) {
+	file.Seek(0, 0)
+
+	if ext == ".heic" || ext == ".heif" || isHEIF(file) {
+		return decodeHEIF(file)
+	}
+
+	if ext == ".webp" {
+		img, err := webp.Decode(file)
+		if err == nil {
+			return img, "webp", nil
+		}
+	}
+
+	if ext == ".bmp" {
+		img, err := bmp.Decode(file)
+		if err == nil {
+			return img, "bmp", nil
+		}
+	}
+
+	if ext == ".tiff" || ext == ".tif" {
+		img, err := tiff.Decode(file)
+		if err == nil {
+			return img, "tiff", nil
+		}
+	}
+
+	file.Seek(0, 0)
+	img, format, err := image.Decode(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("image decode error: %v", err)
+	}
+
+	return img, format, nil
+}
+
+// isHEIF checks the ISO-BMFF "ftyp" box for a HEIF brand, so iPhone photos
+// are recognised even when renamed to .jpg
+func isHEIF(file multipart.File) bool {
+	defer file.Seek(0, 0)
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+	if string(header[4:8]) != "ftyp" {
+		return false
+	}
+
+	switch string(header[8:12]) {
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+		return true
+	}
+	return false
+}
+
+func decodeHEIF(file multipart.File) (image.Image, string, error) {
+	file.Seek(0, 0)
+	img, err := goheif.Decode(file)
+	if err != nil {
+		return nil, "", fmt.Errorf("HEIC decode error: %v", err)
+	}
+
+	return img, "heic", nil
+}
+
+// imageMetadata is what we keep from an image's EXIF block. Everything else,
+// including GPS position, is dropped when the image is re-encoded.
+type imageMetadata struct {
+	Orientation int
+	CapturedAt  *time.Time
+	HasGPS      bool
+}
+
+// readImageMetadata extracts the raw EXIF block of a decoded image file
+// (JPEG APP1, PNG eXIf, WebP EXIF chunk, TIFF header or HEIF item) and
+// parses it
+func readImageMetadata(file multipart.File, format string) imageMetadata {
+	defer file.Seek(0, 0)
+	file.Seek(0, 0)
+
+	var exif []byte
+	switch format {
+	case "heic":
+		exif, _ = goheif.ExtractExif(file)
+	case "jpeg", "png", "webp", "tiff":
+		data, err := ioutil.ReadAll(file)
+		if err != nil {
+			return imageMetadata{Orientation: 1}
+		}
+		exif = findExifBlock(data, format)
+	}
+
+	return parseExif(exif)
+}
+
+func findExifBlock(data []byte, format string) []byte {
+	switch format {
+	case "tiff":
+		return data
+	case "jpeg":
+		for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+			marker := data[i+1]
+			if marker == 0xDA || marker == 0xD9 {
+				break
+			}
+			size := int(binary.BigEndian.Uint16(data[i+2:]))
+			if size < 2 || i+2+size > len(data) {
+				break
+			}
+			segment := data[i+4 : i+2+size]
+			if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				return segment
+			}
+			i += 2 + size
+		}
+	case "png":
+		for i := 8; i+12 <= len(data); {
+			size := int(binary.BigEndian.Uint32(data[i:]))
+			if size < 0 || i+12+size > len(data) {
+				break
+			}
+			if string(data[i+4:i+8]) == "eXIf" {
+				return data[i+8 : i+8+size]
+			}
+			i += 12 + size
+		}
+	case "webp":
+		for i := 12; i+8 <= len(data); {
+			size := int(binary.LittleEndian.Uint32(data[i+4:]))
+			if size < 0 || i+8+size > len(data) {
+				break
+			}
+			if string(data[i:i+4]) == "EXIF" {
+				return data[i+8 : i+8+size]
+			}
+			i += 8 + size + size%2
+		}
+	}
+	return nil
+}
+
+// stripImageMetadata returns the image file without its EXIF, XMP, IPTC
+// and text metadata, leaving the encoded pixels untouched. It returns nil
+// if the file cannot be parsed.
+func stripImageMetadata(data []byte, format string) []byte {
+	var out bytes.Buffer
+	switch format {
+	case "jpeg":
+		if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+			return nil
+		}
+		out.Write(data[:2])
+		for i := 2; ; {
+			if i+4 > len(data) || data[i] != 0xFF {
+				return nil
+			}
+			marker := data[i+1]
+			if marker == 0xDA {
+				// Entropy-coded data follows up to EOI
+				out.Write(data[i:])
+				return out.Bytes()
+			}
+			size := int(binary.BigEndian.Uint16(data[i+2:]))
+			if size < 2 || i+2+size > len(data) {
+				return nil
+			}
+			// APP1 holds EXIF and XMP, APP13 IPTC, COM free text
+			if marker != 0xE1 && marker != 0xED && marker != 0xFE {
+				out.Write(data[i : i+2+size])
+			}
+			i += 2 + size
+		}
+	case "png":
+		if len(data) < 8 {
+			return nil
+		}
+		out.Write(data[:8])
+		for i := 8; i < len(data); {
+			if i+12 > len(data) {
+				return nil
+			}
+			size := int(binary.BigEndian.Uint32(data[i:]))
+			if size < 0 || i+12+size > len(data) {
+				return nil
+			}
+			switch string(data[i+4 : i+8]) {
+			case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+			default:
+				out.Write(data[i : i+12+size])
+			}
+			i += 12 + size
+		}
+		return out.Bytes()
+	case "webp":
+		if len(data) < 12 {
+			return nil
+		}
+		out.Write(data[:12])
+		for i := 12; i < len(data); {
+			if i+8 > len(data) {
+				return nil
+			}
+			size := int(binary.LittleEndian.Uint32(data[i+4:]))
+			end := i + 8 + size + size%2
+			if size < 0 || end > len(data) {
+				return nil
+			}
+			switch string(data[i : i+4]) {
+			case "EXIF", "XMP ":
+			case "VP8X":
+				chunk := append([]byte(nil), data[i:end]...)
+				if len(chunk) > 8 {
+					// Clear the EXIF and XMP flags
+					chunk[8] &^= 0x08 | 0x04
+				}
+				out.Write(chunk)
+			default:
+				out.Write(data[i:end])
+			}
+			i = end
+		}
+		stripped := out.Bytes()
+		binary.LittleEndian.PutUint32(stripped[4:], uint32(len(stripped)-8))
+		return stripped
+	}
+	return nil
+}
+
+// parseExif reads orientation, capture time and GPS presence from a raw EXIF
+// block (TIFF structure, optionally prefixed with "Exif\x00\x00")
+func parseExif(exif []byte) imageMetadata {
+	meta := imageMetadata{Orientation: 1}
+
+	exif = bytes.TrimPrefix(exif, []byte("Exif\x00\x00"))
+	if len(exif) < 8 {
+		return meta
+	}
+
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return meta
+	}
+
+	// readIFD returns the entries of an IFD as tag -> 12-byte entry
+	readIFD := func(offset int) map[uint16][]byte {
+		entries := map[uint16][]byte{}
+		if offset <= 0 || offset+2 > len(exif) {
+			return entries
+		}
+		count := int(order.Uint16(exif[offset:]))
+		for i := 0; i < count; i++ {
+			entry := offset + 2 + i*12
+			if entry+12 > len(exif) {
+				break
+			}
+			entries[order.Uint16(exif[entry:])] = exif[entry : entry+12]
+		}
+		return entries
+	}
+
+	readString := func(entry []byte) string {
+		count := int(order.Uint32(entry[4:]))
+		value := entry[8:12]
+		if count > 4 {
+			offset := int(order.Uint32(entry[8:]))
+			if offset < 0 || offset+count > len(exif) {
+				return ""
+			}
+			value = exif[offset : offset+count]
+		} else {
+			value = value[:count]
+		}
+		return strings.TrimRight(string(value), "\x00 ")
+	}
+
+	ifd0 := readIFD(int(order.Uint32(exif[4:8])))
+
+	if entry, ok := ifd0[0x0112]; ok {
+		if orientation := int(order.Uint16(entry[8:])); orientation >= 1 && orientation <= 8 {
+			meta.Orientation = orientation
+		}
+	}
+
+	_, meta.HasGPS = ifd0[0x8825]
+
+	captured := ""
+	if entry, ok := ifd0[0x8769]; ok {
+		exifIFD := readIFD(int(order.Uint32(entry[8:])))
+		if entry, ok := exifIFD[0x9003]; ok {
+			captured = readString(entry)
+		}
+	}
+	if entry, ok := ifd0[0x0132]; ok && captured == "" {
+		captured = readString(entry)
+	}
+	if t, err := time.ParseInLocation("2006:01:02 15:04:05", captured, time.Local); err == nil {
+		meta.CapturedAt = &t
+	}
+
+	return meta
+}
+
+// applyOrientation rotates/flips an image so it displays upright for the
+// given EXIF orientation
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for dy := 0; dy < dh; dy++ {
+		for dx := 0; dx < dw; dx++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-dx, dy
+			case 3:
+				sx, sy = w-1-dx, h-1-dy
+			case 4:
+				sx, sy = dx, h-1-dy
+			case 5:
+				sx, sy = dy, dx
+			case 6:
+				sx, sy = dy, h-1-dx
+			case 7:
+				sx, sy = w-1-dy, h-1-dx
+			case 8:
+				sx, sy = w-1-dy, dx
+			}
+			si := src.PixOffset(sx, sy)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// loadImageVariants reads IMAGE_VARIANTS, keeping the defaults if unset or invalid
+func loadImageVariants() {
+	value := os.Getenv("IMAGE_VARIANTS")
+	if value == "" {
+		return
+	}
+
+	var variants []imageVariant
+	for _, part := range strings.Split(value, ",") {
+		var width uint
+		name, size, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if _, err := fmt.Sscanf(size, "%d", &width); !ok || err != nil || name == "" || width == 0 {
+			log.Printf("IMAGE_VARIANTS noto'g'ri: %q, standart variantlar ishlatiladi", value)
+			return
+		}
+		variants = append(variants, imageVariant{Name: name, Width: width})
+	}
+
+	sort.Slice(variants, func(i, j int) bool {
+		return variants[i].Width < variants[j].Width
+	})
+	imageVariants = variants
+}
+
+// loadImageStorageFormat reads IMAGE_STORAGE_FORMAT, keeping "original"
+// if unset or invalid
+func loadImageStorageFormat() {
+	value := os.Getenv("IMAGE_STORAGE_FORMAT")
+	if value == "" {
+		return
+	}
+	for _, format := range imageStorageFormats {
+		if value == format {
+			imageStorageFormat = value
+			return
+		}
+	}
+	log.Printf("IMAGE_STORAGE_FORMAT noto'g'ri: %q, original ishlatiladi", value)
+}
+
+// loadUploadLimits reads UPLOAD_MAX_{IMAGE,AUDIO,VIDEO}_MB and
+// UPLOAD_QUOTA_{USER,CATEGORY}_MB
+func loadUploadLimits() {
+	envMB := func(name string, value *int64) {
+		raw := os.Getenv(name)
+		if raw == "" {
+			return
+		}
+		var mb int64
+		if _, err := fmt.Sscanf(raw, "%d", &mb); err != nil || mb < 0 {
+			log.Printf("%s noto'g'ri: %q", name, raw)
+			return
+		}
+		*value = mb << 20
+	}
+
+	envMB("UPLOAD_MAX_IMAGE_MB", &uploadLimits.Image)
+	envMB("UPLOAD_MAX_AUDIO_MB", &uploadLimits.Audio)
+	envMB("UPLOAD_MAX_VIDEO_MB", &uploadLimits.Video)
+	envMB("UPLOAD_QUOTA_USER_MB", &uploadLimits.UserQuota)
+	envMB("UPLOAD_QUOTA_CATEGORY_MB", &uploadLimits.CategoryQuota)
+	envMB("IMAGE_CACHE_MAX_MB", &imageCache.MaxBytes)
+
+	envDuration := func(name string, value *time.Duration) {
+		raw := os.Getenv(name)
+		if raw == "" {
+			return
+		}
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			*value = d
+		} else {
+			log.Printf("%s noto'g'ri: %q", name, raw)
+		}
+	}
+
+	envInt := func(name string, value *int, lo, hi int) {
+		raw := os.Getenv(name)
+		if raw == "" {
+			return
+		}
+		var n int
+		if _, err := fmt.Sscanf(raw, "%d", &n); err != nil || n < lo || n > hi {
+			log.Printf("%s noto'g'ri: %q", name, raw)
+			return
+		}
+		*value = n
+	}
+
+	// 0 only matches identical hashes; 64 matches any image
+	envInt("IMAGE_SIMILAR_DISTANCE", &similarImageDistance, 0, 64)
+	envInt("UPLOAD_BATCH_MAX_FILES", &uploadBatchLimits.MaxFiles, 1, math.MaxInt)
+	envInt("UPLOAD_BATCH_WORKERS", &uploadBatchLimits.Workers, 1, math.MaxInt)
+	envInt("IMAGE_TRANSFORM_WORKERS", &imageCache.Workers, 1, math.MaxInt)
+
+	envDuration("UPLOAD_SESSION_TTL", &uploadSessionTTL)
+	envDuration("MEDIA_URL_TTL", &mediaSigning.TTL)
+	envDuration("UPLOAD_GC_INTERVAL", &uploadGC.Interval)
+	envDuration("UPLOAD_GC_GRACE", &uploadGC.Grace)
+}
+
+func findImageVariant(name string) *imageVariant {
+	for i := range imageVariants {
+		if imageVariants[i].Name == name {
+			return &imageVariants[i]
+		}
+	}
+	return nil
+}
+
+// variantFileName returns the file name of a variant of an uploaded file,
+// e.g. "abc.jpg" + "thumb" -> "abc_thumb.jpg"
+func variantFileName(fileName string, variant string) string {
+	ext := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + "_" + variant + ext
+}
+
+// saveImageVariants writes the smaller variants of an already saved image
+// and returns the URL of every variant. Variants at least as wide as the
+// image point to the stored file itself.
+func saveImageVariants(img image.Image, fileID string, ext string, contentType string) (map[string]string, error) {
+	fileName := fileID + ext
+	variants := map[string]string{}
+	width := uint(img.Bounds().Dx())
+
+	for i, v := range imageVariants {
+		if i == len(imageVariants)-1 || width <= v.Width {
+			variants[v.Name] = blobStore.URL(fileName)
+			continue
+		}
+
+		name := variantFileName(fileName, v.Name)
+		variants[v.Name] = blobStore.URL(name)
+		if _, err := blobStore.Stat(name); err == nil {
+			// Same content uploaded before
+			continue
+		}
+
+		resized := resize.Resize(v.Width, 0, img, resize.Lanczos3)
+		tmpPath := uploadTempPath(ext)
+		err := saveImage(resized, tmpPath, ext)
+		if err == nil {
+			err = putBlobFile(tmpPath, name, contentType)
+		}
+		os.Remove(tmpPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return variants, nil
+}
+
+// BlobStore keeps uploaded files under flat names ("<hash><ext>",
+// "<hash>_<variant><ext>"). URL maps a name to the link handed to clients.
+type BlobStore interface {
+	Put(name string, r io.Reader, size int64, contentType string) error
+	Open(name string) (io.ReadSeekCloser, error)
+	Stat(name string) (BlobInfo, error)
+	// Touch resets the modification time, restarting the GC grace period
+	Touch(name string) error
+	Delete(name string) error
+	List() ([]BlobInfo, error)
+	URL(name string) string
+}
+
+type BlobInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+var errBlobNotFound = errors.New("blob not found")
+
+var blobStore BlobStore
+
+// initBlobStore selects the storage backend from STORAGE_BACKEND: "local"
+// (default, the uploads directory) or "s3"
+func initBlobStore() error {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		if err := os.MkdirAll("uploads", os.ModePerm); err != nil {
+			return err
+		}
+		blobStore = &localBlobStore{Dir: "uploads"}
+	case "s3":
+		store, err := newS3BlobStore()
+		if err != nil {
+			return err
+		}
+		blobStore = store
+	default:
+		return fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+	return nil
+}
+
+// VideoExtractor renders still and animated previews of a video file
+type VideoExtractor interface {
+	// Poster writes one JPEG frame taken at offset
+	Poster(ctx context.Context, src string, dst string, offset time.Duration) error
+	// Preview writes a short looping GIF starting at offset
+	Preview(ctx context.Context, src string, dst string, offset time.Duration) error
+}
+
+// videoExtractor is nil when no extractor is available; videos then get
+// no previews
+var videoExtractor VideoExtractor
+
+// videoPreviews controls what is generated for uploaded videos. The
+// animated preview is opt-in (VIDEO_PREVIEW=true), it takes a few seconds.
+var videoPreviews = struct {
+	Animated bool
+	Timeout  time.Duration
+}{
+	Timeout: time.Minute,
+}
+
+// Blob name suffixes of the files generated for a video, next to
+// "<hash><ext>"
+const (
+	videoPosterSuffix  = "_poster.jpg"
+	videoPreviewSuffix = "_preview.gif"
+)
+
+// initVideoExtractor selects the extractor from VIDEO_EXTRACTOR: "auto"
+// (default, ffmpeg if it is installed), "ffmpeg" or "none". FFMPEG_PATH
+// overrides the binary.
+func initVideoExtractor() error {
+	videoPreviews.Animated = os.Getenv("VIDEO_PREVIEW") == "true"
+
+	binary := ffmpegBinary()
+
+	switch extractor := os.Getenv("VIDEO_EXTRACTOR"); extractor {
+	case "", "auto":
+		if path, err := exec.LookPath(binary); err == nil {
+			videoExtractor = ffmpegTool{Path: path}
+		} else {
+			log.Println("ffmpeg topilmadi, video prevyulari yaratilmaydi")
+		}
+	case "ffmpeg":
+		path, err := exec.LookPath(binary)
+		if err != nil {
+			return err
+		}
+		videoExtractor = ffmpegTool{Path: path}
+	case "none":
+	default:
+		return fmt.Errorf("unknown VIDEO_EXTRACTOR %q", extractor)
+	}
+	return nil
+}
+
+// AudioTranscoder converts audio with an external codec library
+type AudioTranscoder interface {
+	// Transcode writes src as loudness-normalized mono audio in format
+	// ("m4a" or "opus")
+	Transcode(ctx context.Context, src string, dst string, format string) error
+	// DecodePCM writes src as 16-bit mono PCM WAV, for waveform analysis
+	DecodePCM(ctx context.Context, src string, dst string) error
+}
+
+// audioTranscoder is nil when no transcoder is available; only WAV
+// uploads then get a waveform
+var audioTranscoder AudioTranscoder
+
+// audioProcessing controls voice notes. With Format set
+// (AUDIO_TRANSCODE_FORMAT) every audio upload is stored in that format.
+// Peaks (AUDIO_WAVEFORM_PEAKS) is the length of the waveform.
+var audioProcessing = struct {
+	Format  string
+	Peaks   int
+	Timeout time.Duration
+}{
+	Peaks:   100,
+	Timeout: time.Minute,
+}
+
+// audioTranscodeFormats maps AUDIO_TRANSCODE_FORMAT to the stored
+// extension and content type
+var audioTranscodeFormats = map[string]uploadFormat{
+	"m4a":  {"audio/x-m4a", ".m4a", "audio/mp4", nil},
+	"opus": {"audio/ogg", ".ogg", "audio/ogg", nil},
+}
+
+// initAudioTranscoder selects the transcoder from AUDIO_TRANSCODER: "auto"
+// (default, ffmpeg if it is installed), "ffmpeg" or "none"
+func initAudioTranscoder() error {
+	if format := os.Getenv("AUDIO_TRANSCODE_FORMAT"); format != "" {
+		if _, ok := audioTranscodeFormats[format]; !ok {
+			return fmt.Errorf("unknown AUDIO_TRANSCODE_FORMAT %q", format)
+		}
+		audioProcessing.Format = format
+	}
+	if raw := os.Getenv("AUDIO_WAVEFORM_PEAKS"); raw != "" {
+		if _, err := fmt.Sscanf(raw, "%d", &audioProcessing.Peaks); err != nil || audioProcessing.Peaks < 1 || audioProcessing.Peaks > 1000 {
+			return fmt.Errorf("AUDIO_WAVEFORM_PEAKS must be between 1 and 1000")
+		}
+	}
+
+	switch transcoder := os.Getenv("AUDIO_TRANSCODER"); transcoder {
+	case "", "auto":
+		if path, err := exec.LookPath(ffmpegBinary()); err == nil {
+			audioTranscoder = ffmpegTool{Path: path}
+		} else if audioProcessing.Format != "" {
+			log.Println("ffmpeg topilmadi, audio qayta kodlanmaydi")
+		}
+	case "ffmpeg":
+		path, err := exec.LookPath(ffmpegBinary())
+		if err != nil {
+			return err
+		}
+		audioTranscoder = ffmpegTool{Path: path}
+	case "none":
+	default:
+		return fmt.Errorf("unknown AUDIO_TRANSCODER %q", transcoder)
+	}
+	return nil
+}
+
+// ffmpegBinary is FFMPEG_PATH, or ffmpeg from PATH
+func ffmpegBinary() string {
+	if binary := os.Getenv("FFMPEG_PATH"); binary != "" {
+		return binary
+	}
+	return "ffmpeg"
+}
+
+// ffmpegTool runs a local ffmpeg binary
+type ffmpegTool struct {
+	Path string
+}
+
+func (f ffmpegTool) Poster(ctx context.Context, src string, dst string, offset time.Duration) error {
+	return f.run(ctx,
+		"-ss", fmt.Sprintf("%.3f", offset.Seconds()), "-i", src,
+		"-frames:v", "1", "-vf", "scale='min(1280,iw)':-2", "-q:v", "3",
+		"-f", "image2", dst)
+}
+
+func (f ffmpegTool) Preview(ctx context.Context, src string, dst string, offset time.Duration) error {
+	return f.run(ctx,
+		"-ss", fmt.Sprintf("%.3f", offset.Seconds()), "-t", "3", "-i", src,
+		"-vf", "fps=8,scale=320:-2:flags=lanczos,split[a][b];[a]palettegen[p];[b][p]paletteuse",
+		"-loop", "0", "-an", "-f", "gif", dst)
+}
+
+func (f ffmpegTool) Transcode(ctx context.Context, src string, dst string, format string) error {
+	codec := []string{"-c:a", "aac", "-b:a", "64k", "-movflags", "+faststart", "-f", "mp4"}
+	if format == "opus" {
+		codec = []string{"-c:a", "libopus", "-b:a", "32k", "-f", "ogg"}
+	}
+	// Bit-exact output keeps identical voice notes deduplicated
+	args := []string{"-i", src, "-vn", "-ac", "1", "-ar", "48000",
+		"-af", "loudnorm=I=-16:TP=-1.5:LRA=11",
+		"-map_metadata", "-1", "-fflags", "+bitexact", "-flags:a", "+bitexact"}
+	return f.run(ctx, append(append(args, codec...), dst)...)
+}
+
+func (f ffmpegTool) DecodePCM(ctx context.Context, src string, dst string) error {
+	return f.run(ctx, "-i", src, "-vn", "-ac", "1", "-ar", "8000", "-c:a", "pcm_s16le", "-f", "wav", dst)
+}
+
+func (f ffmpegTool) run(ctx context.Context, args ...string) error {
+	args = append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)
+	output, err := exec.CommandContext(ctx, f.Path, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// extractVideoPreviews stores the poster and, if enabled, the animated
+// preview of the video at srcPath and returns their URLs. Previews already
+// stored for the same content are reused. Failures only cost the preview.
+func extractVideoPreviews(srcPath string, hash string, info mediaInfo) (poster string, preview string) {
+	if videoExtractor == nil || info.Width == 0 {
+		return "", ""
+	}
+
+	// A frame from the first second, clear of fade-ins, unless the video
+	// is shorter than that
+	offset := time.Second
+	if info.DurationMs != nil && time.Duration(*info.DurationMs)*time.Millisecond < 2*offset {
+		offset = time.Duration(*info.DurationMs) * time.Millisecond / 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), videoPreviews.Timeout)
+	defer cancel()
+
+	generate := func(name string, contentType string, render func(string) error) string {
+		if _, err := blobStore.Stat(name); err == nil {
+			return blobStore.URL(name)
+		}
+		tmpPath := uploadTempPath(filepath.Ext(name))
+		defer os.Remove(tmpPath)
+		err := render(tmpPath)
+		if err == nil {
+			err = putBlobFile(tmpPath, name, contentType)
+		}
+		if err != nil {
+			log.Printf("Video prevyusi yaratilmadi (%s): %v", name, err)
+			return ""
+		}
+		return blobStore.URL(name)
+	}
+
+	poster = generate(hash+videoPosterSuffix, "image/jpeg", func(dst string) error {
+		return videoExtractor.Poster(ctx, srcPath, dst, offset)
+	})
+	if videoPreviews.Animated {
+		preview = generate(hash+videoPreviewSuffix, "image/gif", func(dst string) error {
+			return videoExtractor.Preview(ctx, srcPath, dst, 0)
+		})
+	}
+	return poster, preview
+}
+
+// blobNameFromURL returns the blob name behind an upload URL, or false if
+// the URL does not point into the blob store. Signed /media links are
+// accepted too.
+func blobNameFromURL(url string) (string, bool) {
+	if i := strings.IndexByte(url, '?'); i >= 0 {
+		url = url[:i]
+	}
+	for _, prefix := range []string{"/static/", "/media/", blobStore.URL("")} {
+		if strings.HasPrefix(url, prefix) {
+			name := path.Base(strings.TrimPrefix(url, prefix))
+			return name, name != "." && name != "/"
+		}
+	}
+	return "", false
+}
+
+func loadMediaSigning() {
+	mediaSigning.PublicStatic = os.Getenv("MEDIA_PUBLIC_STATIC") != "false"
+	mediaSigning.Key = []byte(os.Getenv("MEDIA_SIGNING_KEY"))
+	if len(mediaSigning.Key) == 0 && !mediaSigning.PublicStatic {
+		// Links stay valid only until restart and only on this instance
+		mediaSigning.Key = make([]byte, 32)
+		rand.Read(mediaSigning.Key)
+		log.Println("MEDIA_SIGNING_KEY berilmagan, vaqtinchalik kalit ishlatiladi")
+	}
+}
+
+func mediaSignature(name string, exp int64) string {
+	mac := hmac.New(sha256.New, mediaSigning.Key)
+	fmt.Fprintf(mac, "%s\n%d", name, exp)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// mediaURL turns a stored upload URL into the link handed to clients: a
+// freshly signed /media link, or the URL itself with public /static
+func mediaURL(url string) string {
+	if mediaSigning.PublicStatic {
+		return url
+	}
+	name, ok := blobNameFromURL(url)
+	if !ok {
+		return url
+	}
+	exp := time.Now().Add(mediaSigning.TTL).Unix()
+	return fmt.Sprintf("/media/%s?exp=%d&sig=%s", name, exp, mediaSignature(name, exp))
+}
+
+// canonicalUploadURL maps a signed /media link sent back by a client to the
+// stored upload URL
+func canonicalUploadURL(url string) string {
+	if !strings.HasPrefix(url, "/media/") {
+		return url
+	}
+	if name, ok := blobNameFromURL(url); ok {
+		return blobStore.URL(name)
+	}
+	return url
+}
+
+// signedUpload returns a copy of the upload with a client link as URL
+func signedUpload(upload *Upload) *Upload {
+	if upload == nil {
+		return nil
+	}
+	signed := *upload
+	signed.URL = mediaURL(upload.URL)
+	signed.Poster = mediaURL(upload.Poster)
+	signed.Preview = mediaURL(upload.Preview)
+	return &signed
+}
+
+// signUploadData adds client links to a fresh upload. URL stays the stored
+// URL so it can be attached to task items.
+func signUploadData(data *UploadData) {
+	if mediaSigning.PublicStatic {
+		return
+	}
+	data.SignedURL = mediaURL(data.URL)
+	data.Poster = mediaURL(data.Poster)
+	data.Preview = mediaURL(data.Preview)
+	for name, url := range data.Variants {
+		data.Variants[name] = mediaURL(url)
+	}
+}
+
+// putBlobFile copies a local file into the blob store
+func putBlobFile(localPath string, name string, contentType string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return blobStore.Put(name, f, info.Size(), contentType)
+}
+
+func hashBlob(name string) (string, error) {
+	blob, err := blobStore.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, blob); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// localBlobStore keeps blobs in a directory served under /static
+type localBlobStore struct {
+	Dir string
+}
+
+func (s *localBlobStore) Put(name string, r io.Reader, size int64, contentType string) error {
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.Dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *localBlobStore) Open(name string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, errBlobNotFound
+	}
+	return f, err
+}
+
+func (s *localBlobStore) Stat(name string) (BlobInfo, error) {
+	info, err := os.Stat(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return BlobInfo{}, errBlobNotFound
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *localBlobStore) Touch(name string) error {
+	now := time.Now()
+	return os.Chtimes(filepath.Join(s.Dir, name), now, now)
+}
+
+func (s *localBlobStore) Delete(name string) error {
+	err := os.Remove(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *localBlobStore) List() ([]BlobInfo, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := []BlobInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		blobs = append(blobs, BlobInfo{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	return blobs, nil
+}
+
+func (s *localBlobStore) URL(name string) string {
+	return "/static/" + name
+}
+
+// s3BlobStore keeps blobs in an S3-compatible bucket (AWS S3, MinIO).
+// Without S3_PUBLIC_URL the files are still served through /static.
+type s3BlobStore struct {
+	client    *minio.Client
+	bucket    string
+	prefix    string
+	publicURL string
+}
+
+// newS3BlobStore is configured by S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY,
+// S3_SECRET_KEY, S3_REGION, S3_USE_SSL, S3_PREFIX and S3_PUBLIC_URL
+func newS3BlobStore() (*s3BlobStore, error) {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	bucket := os.Getenv("S3_BUCKET")
+	if endpoint == "" || bucket == "" {
+		return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required")
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"), ""),
+		Secure: os.Getenv("S3_USE_SSL") != "false",
+		Region: os.Getenv("S3_REGION"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{Region: os.Getenv("S3_REGION")}); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s3BlobStore{
+		client:    client,
+		bucket:    bucket,
+		prefix:    os.Getenv("S3_PREFIX"),
+		publicURL: strings.TrimSuffix(os.Getenv("S3_PUBLIC_URL"), "/"),
+	}, nil
+}
+
+func s3NotFound(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchKey" || code == "NotFound"
+}
+
+func (s *s3BlobStore) Put(name string, r io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(context.Background(), s.bucket, s.prefix+name, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	return err
+}
+
+func (s *s3BlobStore) Open(name string) (io.ReadSeekCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.prefix+name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	// GetObject is lazy; Stat surfaces a missing key
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if s3NotFound(err) {
+			return nil, errBlobNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *s3BlobStore) Stat(name string) (BlobInfo, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.prefix+name, minio.StatObjectOptions{})
+	if err != nil {
+		if s3NotFound(err) {
+			return BlobInfo{}, errBlobNotFound
+		}
+		return BlobInfo{}, err
+	}
+	return BlobInfo{Name: name, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+// Touch copies the object onto itself, which updates LastModified
+func (s *s3BlobStore) Touch(name string) error {
+	ctx := context.Background()
+	info, err := s.client.StatObject(ctx, s.bucket, s.prefix+name, minio.StatObjectOptions{})
+	if err != nil {
+		return err
+	}
+	_, err = s.client.CopyObject(ctx,
+		minio.CopyDestOptions{
+			Bucket:          s.bucket,
+			Object:          s.prefix + name,
+			ReplaceMetadata: true,
+			UserMetadata: map[string]string{
+				"Content-Type": info.ContentType,
+				"Touched":      time.Now().UTC().Format(time.RFC3339),
+			},
+		},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: s.prefix + name},
+	)
+	return err
+}
+
+func (s *s3BlobStore) Delete(name string) error {
+	return s.client.RemoveObject(context.Background(), s.bucket, s.prefix+name, minio.RemoveObjectOptions{})
+}
+
+func (s *s3BlobStore) List() ([]BlobInfo, error) {
+	blobs := []BlobInfo{}
+	for obj := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		blobs = append(blobs, BlobInfo{
+			Name:    strings.TrimPrefix(obj.Key, s.prefix),
+			Size:    obj.Size,
+			ModTime: obj.LastModified,
+		})
+	}
+	return blobs, nil
+}
+
+func (s *s3BlobStore) URL(name string) string {
+	if s.publicURL == "" {
+		return "/static/" + name
+	}
+	return s.publicURL + "/" + name
+}
+
+// existingVariants lists the variant URLs stored for an image upload
+func existingVariants(upload Upload) map[string]string {
+	if !strings.HasPrefix(upload.ContentType, "image/") {
+		return nil
+	}
+
+	fileName, _ := blobNameFromURL(upload.URL)
+	variants := map[string]string{}
+	for _, v := range imageVariants {
+		variants[v.Name] = upload.URL
+		name := variantFileName(fileName, v.Name)
+		if _, err := blobStore.Stat(name); err == nil {
+			variants[v.Name] = blobStore.URL(name)
+		}
+	}
+	return variants
+}
+
+// storeContentAddressed moves a freshly written temp file into the blob
+// store as <hash><ext>. If that blob already exists the copy is skipped and
+// the existing one is touched, restarting its garbage collection grace period.
+func storeContentAddressed(tmpPath string, hash string, ext string, contentType string) (fileName string, existed bool, err error) {
+	defer os.Remove(tmpPath)
+
+	fileName = hash + ext
+	if _, err := blobStore.Stat(fileName); err == nil {
+		blobStore.Touch(fileName)
+		return fileName, true, nil
+	}
+
+	if err := putBlobFile(tmpPath, fileName, contentType); err != nil {
+		return "", false, err
+	}
+	return fileName, false, nil
+}
+
+// uploadTempPath is where an upload is written before it is hashed and
+// handed to the blob store
+func uploadTempPath(ext string) string {
+	return filepath.Join("uploads_tmp", ".tmp-"+uuid.New().String()+ext)
+}
+
+// imageTransform is a resize and re-encode requested with w, h, fit, q and
+// format on a media URL
+type imageTransform struct {
+	Width   int
+	Height  int
+	Fit     string
+	Quality int
+	Format  string
+}
+
+// maxTransformSize bounds w and h of a transform request
+const maxTransformSize = 4096
+
+var transformFormats = map[string]string{
+	"jpeg": ".jpg",
+	"png":  ".png",
+	"webp": ".webp",
+}
+
+// imageCache keeps transformed images on local disk. Files are touched on
+// every hit and the least recently used are evicted beyond MaxBytes
+// (IMAGE_CACHE_MAX_MB). At most Workers cache misses are decoded and
+// resized at once (IMAGE_TRANSFORM_WORKERS); further requests wait.
+var imageCache = struct {
+	sync.Mutex
+	Dir      string
+	MaxBytes int64
+	Workers  int
+	size     int64
+	slots    chan struct{}
+}{
+	Dir:      "uploads_cache",
+	MaxBytes: 256 << 20,
+	Workers:  2,
+}
+
+// parseImageTransform reads transform parameters. It returns nil when none
+// are given.
+func parseImageTransform(c *gin.Context, fileName string) (*imageTransform, error) {
+	if c.Query("w") == "" && c.Query("h") == "" && c.Query("fit") == "" && c.Query("q") == "" && c.Query("format") == "" {
+		return nil, nil
+	}
+
+	t := &imageTransform{Fit: "contain", Quality: 85}
+
+	for _, p := range []struct {
+		name  string
+		value *int
+		min   int
+		max   int
+	}{
+		{"w", &t.Width, 1, maxTransformSize},
+		{"h", &t.Height, 1, maxTransformSize},
+		{"q", &t.Quality, 1, 100},
+	} {
+		raw := c.Query(p.name)
+		if raw == "" {
+			continue
+		}
+		var v int
+		if _, err := fmt.Sscanf(raw, "%d", &v); err != nil || v < p.min || v > p.max {
+			return nil, fmt.Errorf("%s must be between %d and %d", p.name, p.min, p.max)
+		}
+		*p.value = v
+	}
+
+	if fit := c.Query("fit"); fit != "" {
+		if fit != "contain" && fit != "cover" && fit != "fill" {
+			return nil, fmt.Errorf("fit must be contain, cover or fill")
+		}
+		t.Fit = fit
+	}
+
+	t.Format = c.Query("format")
+	if t.Format == "" {
+		t.Format = negotiateImageFormat(c.GetHeader("Accept"), fileName)
+	}
+	if _, ok := transformFormats[t.Format]; !ok {
+		return nil, fmt.Errorf("format must be jpeg, png or webp")
+	}
+
+	return t, nil
+}
+
+// negotiateImageFormat picks the output format for a transform without
+// format=. WebP is only chosen for graphics when the client names it: the
+// encoder is lossless, which beats PNG but not JPEG for photos. AVIF is
+// never offered, there is no pure-Go encoder for it.
+func negotiateImageFormat(accept string, fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".png", ".gif":
+		if q, exact := acceptQuality(accept, "image/webp"); exact && q > 0 {
+			return "webp"
+		}
+		return "png"
+	case ".webp":
+		if q, _ := acceptQuality(accept, "image/webp"); q > 0 {
+			return "webp"
+		}
+	}
+	return "jpeg"
+}
+
+// acceptQuality returns the q value an Accept header gives contentType and
+// whether it was named exactly rather than through a wildcard. An empty
+// header accepts everything.
+func acceptQuality(accept string, contentType string) (float64, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return 1, false
+	}
+
+	best, exact := 0.0, false
+	specificity := -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				fmt.Sscanf(v, "%g", &q)
+			}
+		}
+
+		var level int
+		switch {
+		case mediaRange == contentType:
+			level = 2
+		case mediaRange == "*/*":
+			level = 0
+		case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(mediaRange, "*")):
+			level = 1
+		default:
+			continue
+		}
+		// The most specific matching range decides
+		if level > specificity {
+			specificity, best, exact = level, q, level == 2
+		}
+	}
+	return best, exact
+}
+
+// cacheName identifies the transformed file. Blob names are content hashes,
+// so it never needs invalidating.
+func (t imageTransform) cacheName(fileName string) string {
+	key := fmt.Sprintf("%s|%d|%d|%s|%d|%s", fileName, t.Width, t.Height, t.Fit, t.Quality, t.Format)
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16]) + transformFormats[t.Format]
+}
+
+// apply resizes img. contain fits inside the box without upscaling, cover
+// fills the box and crops the overflow, fill stretches to the box.
+func (t imageTransform) apply(img image.Image) image.Image {
+	width, height := uint(t.Width), uint(t.Height)
+	if width == 0 && height == 0 {
+		return img
+	}
+
+	switch t.Fit {
+	case "fill":
+		return resize.Resize(width, height, img, resize.Lanczos3)
+	case "cover":
+		if width == 0 || height == 0 {
+			break
+		}
+		// Crop the source to the target aspect ratio first, so only the
+		// visible part is resized: scaling the whole image up could make
+		// it arbitrarily large for extreme aspect ratios
+		b := img.Bounds()
+		cropW, cropH := b.Dx(), b.Dy()
+		if b.Dx()*int(height) > b.Dy()*int(width) {
+			cropW = max(1, int(math.Round(float64(b.Dy())*float64(width)/float64(height))))
+		} else {
+			cropH = max(1, int(math.Round(float64(b.Dx())*float64(height)/float64(width))))
+		}
+		x := b.Min.X + (b.Dx()-cropW)/2
+		y := b.Min.Y + (b.Dy()-cropH)/2
+		cropped := image.NewRGBA(image.Rect(0, 0, cropW, cropH))
+		draw.Draw(cropped, cropped.Bounds(), img, image.Pt(x, y), draw.Src)
+		return resize.Resize(width, height, cropped, resize.Lanczos3)
+	}
+
+	if width == 0 {
+		width = maxTransformSize
+	}
+	if height == 0 {
+		height = maxTransformSize
+	}
+	return resize.Thumbnail(width, height, img, resize.Lanczos3)
+}
+
+func (t imageTransform) encode(w io.Writer, img image.Image) error {
+	switch t.Format {
+	case "png":
+		return png.Encode(w, img)
+	case "webp":
+		// Pure-Go encoder, lossless only: quality does not apply
+		return nativewebp.Encode(w, img, nil)
+	default:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: t.Quality})
+	}
+}
+
+// transformImage returns the path of the transformed image, producing it
+// from the stored blob on a cache miss
+func transformImage(fileName string, t imageTransform) (string, error) {
+	name := t.cacheName(fileName)
+	if path, ok := imageCacheGet(name); ok {
+		return path, nil
+	}
+
+	imageCache.slots <- struct{}{}
+	defer func() { <-imageCache.slots }()
+	// Another request may have produced it while this one waited
+	if path, ok := imageCacheGet(name); ok {
+		return path, nil
+	}
+
+	blob, err := blobStore.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+
+	file, ok := blob.(multipart.File)
+	if !ok {
+		return "", fmt.Errorf("blob is not seekable")
+	}
+	img, _, err := decodeImage(file, strings.ToLower(filepath.Ext(fileName)))
+	if err != nil {
+		return "", err
+	}
+	img = t.apply(img)
+
+	tmpPath := uploadTempPath(transformFormats[t.Format])
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return "", err
+	}
+	err = t.encode(out, img)
+	out.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	return imageCachePut(name, tmpPath)
+}
+
+func initImageCache() {
+	os.MkdirAll(imageCache.Dir, os.ModePerm)
+	imageCache.slots = make(chan struct{}, imageCache.Workers)
+
+	entries, _ := os.ReadDir(imageCache.Dir)
+	imageCache.Lock()
+	defer imageCache.Unlock()
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			imageCache.size += info.Size()
+		}
+	}
+	evictImageCache("")
+}
+
+func imageCacheGet(name string) (string, bool) {
+	path := filepath.Join(imageCache.Dir, name)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return path, true
+}
+
+func imageCachePut(name string, tmpPath string) (string, error) {
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(imageCache.Dir, name)
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	imageCache.Lock()
+	imageCache.size += info.Size()
+	evictImageCache(name)
+	imageCache.Unlock()
+
+	return path, nil
+}
+
+// evictImageCache removes least recently used files until the cache fits,
+// never the file just added (keep). The caller holds imageCache.
+func evictImageCache(keep string) {
+	if imageCache.size <= imageCache.MaxBytes {
+		return
+	}
+
+	entries, err := os.ReadDir(imageCache.Dir)
+	if err != nil {
+		return
+	}
+	files := []os.FileInfo{}
+	var total int64
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			files = append(files, info)
+			total += info.Size()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+
+	for _, info := range files {
+		if total <= imageCache.MaxBytes {
+			break
+		}
+		if info.Name() == keep {
+			continue
+		}
+		if err := os.Remove(filepath.Join(imageCache.Dir, info.Name())); err == nil {
+			total -= info.Size()
+		}
+	}
+	imageCache.size = total
+}
+
+// uploadDiskSize returns the bytes an upload occupies, variants included
+func uploadDiskSize(data UploadData) int64 {
+	size := data.Size
+	urls := []string{data.Poster, data.Preview}
+	for _, url := range data.Variants {
+		urls = append(urls, url)
+	}
+	for _, url := range urls {
+		if url == "" || url == data.URL {
+			continue
+		}
+		name, _ := blobNameFromURL(url)
+		if info, err := blobStore.Stat(name); err == nil {
+			size += info.Size
+		}
+	}
+	return size
+}
+
+func uploadUsage(ownerID string, categoryID string) (int64, int) {
+	var bytes int64
+	files := 0
+	for _, u := range db.Uploads {
+		if (ownerID == "" || u.OwnerID == ownerID) && (categoryID == "" || u.CategoryID == categoryID) {
+			bytes += u.Size
+			files++
+		}
+	}
+	return bytes, files
+}
+
+// checkQuota reports whether adding size bytes would exceed the quota of
+// the owner or the category
+func checkQuota(ownerID string, categoryID string, size int64) *uploadFailure {
+	if ownerID != "" && uploadLimits.UserQuota > 0 {
+		if used, _ := uploadUsage(ownerID, ""); used+size > uploadLimits.UserQuota {
+			return &uploadFailure{413, errCodeQuotaExceeded, fmt.Sprintf("Foydalanuvchi xotira limiti tugadi (%d / %d bayt)", used, uploadLimits.UserQuota)}
+		}
+	}
+	if categoryID != "" && uploadLimits.CategoryQuota > 0 {
+		if used, _ := uploadUsage("", categoryID); used+size > uploadLimits.CategoryQuota {
+			return &uploadFailure{413, errCodeQuotaExceeded, fmt.Sprintf("Kategoriya xotira limiti tugadi (%d / %d bayt)", used, uploadLimits.CategoryQuota)}
+		}
+	}
+	return nil
+}
+
+// recordUpload checks quotas and saves the upload record. If the quota is
+// exceeded the stored files are removed again. Content that is already
+// registered is not charged again: data is pointed at the existing upload.
+func recordUpload(data *UploadData, ownerID string, categoryID string) *uploadFailure {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if existing := findUploadByHash(data.Hash); existing != nil {
+		if existing.URL != data.URL && findUploadByURL(data.URL) == nil {
+			// Registered before content addressing under another name
+			removeUploadFile(data.URL)
+			data.URL = existing.URL
+			data.Variants = existingVariants(*existing)
+		}
+		// Uploaded before previews or waveforms were available
+		if existing.Poster == "" && data.Poster != "" {
+			existing.Poster, existing.Preview = data.Poster, data.Preview
+			saveDatabase()
+		}
+		if existing.Waveform == nil && data.Waveform != nil {
+			existing.Waveform = data.Waveform
+			saveDatabase()
+		}
+		if existing.PHash == "" && data.PHash != "" {
+			existing.PHash = data.PHash
+			saveDatabase()
+		}
+		if existing.CapturedAt == nil && data.CapturedAt != nil {
+			existing.CapturedAt = data.CapturedAt
+			saveDatabase()
+		}
+		// Handed out again before being attached: restart the grace
+		// period so the garbage collector does not take it in between
+		if existing.RefCount == 0 {
+			now := time.Now()
+			existing.UnreferencedAt = &now
+			saveDatabase()
+		}
+		data.ID = existing.ID
+		data.Duplicate = true
+		return nil
+	}
+
+	size := uploadDiskSize(*data)
+	if failure := checkQuota(ownerID, categoryID, size); failure != nil {
+		removeUploadFile(data.URL)
+		return failure
+	}
+
+	db.Uploads = append(db.Uploads, Upload{
+		ID:          data.ID,
+		OwnerID:     ownerID,
+		CategoryID:  categoryID,
+		URL:         data.URL,
+		FileName:    data.FileName,
+		ContentType: data.ContentType,
+		Size:        size,
+		Hash:        data.Hash,
+		DurationMs:  data.DurationMs,
+		Width:       data.Width,
+		Height:      data.Height,
+		Codec:       data.Codec,
+		Poster:      data.Poster,
+		Preview:     data.Preview,
+		Waveform:    data.Waveform,
+		PHash:       data.PHash,
+		CapturedAt:  data.CapturedAt,
+		CreatedAt:   time.Now(),
+	})
+	saveDatabase()
+	return nil
+}
+
+func findUploadByID(id string) *Upload {
+	if id == "" {
+		return nil
+	}
+	for i := range db.Uploads {
+		if db.Uploads[i].ID == id {
+			return &db.Uploads[i]
+		}
+	}
+	return nil
+}
+
+func findUploadByHash(hash string) *Upload {
+	if hash == "" {
+		return nil
+	}
+	for i := range db.Uploads {
+		if db.Uploads[i].Hash == hash {
+			return &db.Uploads[i]
+		}
+	}
+	return nil
+}
+
+// findUploadByFile returns the upload stored under the blob name fileName
+func findUploadByFile(fileName string) *Upload {
+	for i := range db.Uploads {
+		if name, ok := blobNameFromURL(db.Uploads[i].URL); ok && name == fileName {
+			return &db.Uploads[i]
+		}
+	}
+	return nil
+}
+
+func findUploadByURL(url string) *Upload {
+	if url == "" {
+		return nil
+	}
+	for i := range db.Uploads {
+		if db.Uploads[i].URL == url {
+			return &db.Uploads[i]
+		}
+	}
+	return nil
+}
+
+// deleteUploadRecord forgets the upload stored under a URL
+func deleteUploadRecord(url string) {
+	for i := range db.Uploads {
+		if db.Uploads[i].URL == url {
+			db.Uploads = append(db.Uploads[:i], db.Uploads[i+1:]...)
+			return
+		}
+	}
+}
+
+// linkTaskItemUpload points a task item at its upload. An explicit
+// upload_id sets Data to the upload URL; otherwise a Data URL that belongs
+// to a registered upload is linked.
+func linkTaskItemUpload(item *TaskItem) error {
+	item.Upload = nil
+	item.Similar = nil
+	item.Warning = ""
+	item.Data = canonicalUploadURL(item.Data)
+	if item.UploadID != "" {
+		upload := findUploadByID(item.UploadID)
+		if upload == nil {
+			return fmt.Errorf("upload not found")
+		}
+		item.Data = upload.URL
+		return nil
+	}
+	if upload := findUploadByURL(item.Data); upload != nil {
+		item.UploadID = upload.ID
+	}
+	return nil
+}
+
+// withUpload returns a copy of the item with its upload embedded and data
+// turned into a client link
+func withUpload(item TaskItem) TaskItem {
+	item.Data = mediaURL(item.Data)
+	item.Upload = signedUpload(findUploadByID(item.UploadID))
+	return item
+}
+
+func withUploads(items []TaskItem) []TaskItem {
+	result := make([]TaskItem, 0, len(items))
+	for _, item := range items {
+		result = append(result, withUpload(item))
+	}
+	return result
+}
+
+func retainUpload(id string) {
+	if upload := findUploadByID(id); upload != nil {
+		upload.RefCount++
+		upload.UnreferencedAt = nil
+	}
+}
+
+// releaseUpload drops one reference and returns the upload, or nil if it
+// is not registered. The last release stamps UnreferencedAt.
+func releaseUpload(id string) *Upload {
+	upload := findUploadByID(id)
+	if upload != nil && upload.RefCount > 0 {
+		upload.RefCount--
+		if upload.RefCount == 0 {
+			now := time.Now()
+			upload.UnreferencedAt = &now
+		}
+	}
+	return upload
+}
+
+// deleteTaskItemUpload releases the task item's upload. Unreferenced files
+// are not deleted here but left for the garbage collector: the same content
+// may just have been uploaded again, handing out this upload to a request
+// that has not attached it yet.
+func deleteTaskItemUpload(item TaskItem) {
+	releaseUpload(item.UploadID)
+}
+
+// removeUploadFile deletes an uploaded file from the blob store, together
+// with its image variants
+func removeUploadFile(url string) {
+	fileName, ok := blobNameFromURL(url)
+	if !ok {
+		return
+	}
+
+	blobStore.Delete(fileName)
+	for _, v := range imageVariants {
+		blobStore.Delete(variantFileName(fileName, v.Name))
+	}
+	stem := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	blobStore.Delete(stem + videoPosterSuffix)
+	blobStore.Delete(stem + videoPreviewSuffix)
+}
+
+// saveImage re-encodes an image. None of the encoders write EXIF/XMP, so the
+// saved file never carries the original metadata.
+func saveImage(img image.Image, savePath string, originalExt string) error {
+	out, err := os.Create(savePath)
+	if err != nil {
+		return fmt.Errorf("file creation error: %v", err)
 	}
 	defer out.Close()
 
-	switch strings.ToLower(originalExt) {
-	case ".png":
-		return png.Encode(out, img)
-	case ".gif":
-		return gif.Encode(out, img, nil)
-	case ".bmp":
-		return bmp.Encode(out, img)
-	case ".tiff", ".tif":
-		return tiff.Encode(out, img, nil)
+	switch strings.ToLower(originalExt) {
+	case ".png":
+		return png.Encode(out, img)
+	case ".gif":
+		return gif.Encode(out, img, nil)
+	case ".bmp":
+		return bmp.Encode(out, img)
+	case ".tiff", ".tif":
+		return tiff.Encode(out, img, nil)
+	case ".webp":
+		if imageStorageFormat == "webp-lossy" {
+			img = quantizeImage(img)
+		}
+		return nativewebp.Encode(out, img, nil)
+	default:
+		opts := &jpeg.Options{Quality: 90}
+		return jpeg.Encode(out, flattenImage(img), opts)
+	}
+}
+
+// quantizeImage rounds the colour channels to a multiple of 4 (255 at the
+// top). The lossless WebP encoder then finds far more repetition, at an
+// error of at most 2 levels. Alpha is kept exact so opaque stays opaque.
+func quantizeImage(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewNRGBA(b)
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	for i, v := range dst.Pix {
+		if i%4 == 3 {
+			continue
+		}
+		dst.Pix[i] = uint8(min((int(v)+2)&^3, 255))
+	}
+	return dst
+}
+
+// flattenImage puts a transparent image on a white background, since JPEG
+// has no alpha channel and would otherwise turn transparency black
+func flattenImage(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); !ok || o.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
+
+func main() {
+	// Copy decoded HEIC pixels out of libde265 memory, which is freed
+	// as soon as goheif.Decode returns
+	goheif.SafeEncoding = true
+
+	loadImageVariants()
+	loadImageStorageFormat()
+	loadUploadLimits()
+	loadMediaSigning()
+	if err := initBlobStore(); err != nil {
+		log.Fatal("Fayl saqlash xatolik:", err)
+	}
+	if err := initVideoExtractor(); err != nil {
+		log.Fatal("Video extractor xatolik:", err)
+	}
+	if err := initAudioTranscoder(); err != nil {
+		log.Fatal("Audio transcoder xatolik:", err)
+	}
+	os.MkdirAll("uploads_tmp", os.ModePerm)
+
+	if len(os.Args) > 1 && os.Args[1] == "gc" {
+		runGC(os.Args[2:])
+		return
+	}
+
+	if err := loadDatabase(); err != nil {
+		log.Fatal("Database yuklashda xatolik:", err)
+	}
+	log.Println("✓ Database muvaffaqiyatli yuklandi")
+
+	initImageCache()
+	go backfillImageHashes()
+	go expireUploadSessionsLoop()
+	if uploadGC.Interval > 0 {
+		go collectGarbageLoop()
+	}
+
+	r := gin.Default()
+
+	// CORS middleware qo'shish
+	r.Use(CORSMiddleware())
+
+	r.GET("/static/*filepath", serveStatic)
+	r.HEAD("/static/*filepath", serveStatic)
+	r.GET("/media/*filepath", serveMedia)
+	r.HEAD("/media/*filepath", serveMedia)
+
+	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+
+	// Category routes
+	r.POST("/categories", createCategory)
+	r.GET("/categories", getCategories)
+	r.GET("/categories/:id", getCategoryWithTasks)
+	r.PUT("/categories/:id", updateCategory)
+	r.DELETE("/categories/:id", deleteCategory)
+	r.GET("/categories/:id/workflow", getCategoryWorkflow)
+	r.GET("/categories/:id/tags", getCategoryTagCounts)
+	r.PUT("/categories/:id/workflow", updateCategoryWorkflow)
+
+	// Task routes
+	r.POST("/tasks", createTask)
+	r.GET("/tasks", getTasks)
+	r.GET("/tasks/deleted", getDeletedTasks)
+	r.GET("/tasks/changes", getTaskChanges)
+	r.GET("/tasks/:id", getTask)
+	r.PUT("/tasks/:id", updateTask)
+	r.PUT("/tasks/:id/position", updateTaskPosition)
+	r.DELETE("/tasks/:id", deleteTask)
+	r.PUT("/tasks/:id/restore", restoreTask)
+	r.DELETE("/tasks/:id/permanent", permanentDeleteTask)
+	r.PUT("/tasks/:id/success", markTaskSuccess)
+	r.GET("/tasks/:id/transitions", getTaskTransitions)
+	r.PUT("/tasks/:id/priority", updateTaskPriority)
+	r.PUT("/tasks/:id/pin", pinTask)
+	r.POST("/tasks/:id/transition", transitionTask)
+
+	// Assignment routes
+	r.POST("/tasks/:id/assignees", assignTask)
+	r.PUT("/tasks/:id/assignees", reassignTask)
+	r.DELETE("/tasks/:id/assignees/:user_id", unassignTask)
+	r.GET("/tasks/:id/assignments", getTaskAssignments)
+	r.GET("/me/tasks", getMyTasks)
+
+	// Tag routes
+	r.POST("/tags", createTag)
+	r.GET("/tags", getTags)
+	r.GET("/tags/:id", getTag)
+	r.PUT("/tags/:id", updateTag)
+	r.DELETE("/tags/:id", deleteTag)
+	r.POST("/tasks/:id/tags", addTaskTags)
+	r.DELETE("/tasks/:id/tags/:tag_id", removeTaskTag)
+
+	// Task Item routes
+	r.POST("/task-items", createTaskItem)
+	r.GET("/task-items", getTaskItems)
+	r.GET("/task-items/:id", getTaskItem)
+	r.GET("/tasks/:id/items", getTaskItemsByTaskID)
+	r.POST("/tasks/:id/attachments", attachTaskFiles)
+	r.PUT("/task-items/:id", updateTaskItem)
+	r.DELETE("/task-items/:id", deleteTaskItem)
+	r.PUT("/task-items/:id/check", checkTaskItem)
+
+	// File upload routes
+	r.POST("/upload/image", uploadImage)
+	r.POST("/upload/audio", uploadAudio)
+	r.POST("/upload/video", uploadVideo)
+	r.POST("/upload/batch", uploadBatch)
+	r.GET("/uploads", getUploads)
+	r.GET("/uploads/usage", getUploadUsage)
+	r.GET("/uploads/:id", getUpload)
+	r.GET("/uploads/:id/similar", getSimilarUploads)
+	r.POST("/uploads/gc", collectUploadGarbage)
+
+	// Resumable upload routes
+	r.POST("/uploads/sessions", createUploadSession)
+	r.GET("/uploads/sessions/:id", getUploadSession)
+	r.HEAD("/uploads/sessions/:id", getUploadSession)
+	r.PATCH("/uploads/sessions/:id", patchUploadSession)
+	r.POST("/uploads/sessions/:id/finalize", finalizeUploadSession)
+	r.DELETE("/uploads/sessions/:id", deleteUploadSession)
+
+	log.Println("🚀 Server running on :1212")
+	log.Println("📚 Swagger: http://localhost:1212/swagger/index.html")
+	r.Run(":1212")
+}
+
+// @Summary Serve uploaded file
+// @Description Serve an uploaded file. For images, ?variant=thumb|medium|full returns the resized copy, falling back to the stored file. With w, h, fit, q or format an image is resized and re-encoded on the fly; results are cached on disk. Images in a format the Accept header rules out are converted. Range requests are supported for seeking in audio and video; content-addressed files are sent as immutable with the content hash as ETag. Closed with MEDIA_PUBLIC_STATIC=false; then use the signed /media links from responses.
+// @Tags uploads
+// @Param filepath path string true "File name"
+// @Param variant query string false "Image variant name"
+// @Param w query int false "Transform: width in pixels"
+// @Param h query int false "Transform: height in pixels"
+// @Param fit query string false "Transform: contain (default), cover or fill"
+// @Param q query int false "Transform: JPEG quality 1-100 (default 85)"
+// @Param format query string false "Transform: jpeg, png or webp (lossless); chosen from the Accept header when omitted"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /static/{filepath} [get]
+func serveStatic(c *gin.Context) {
+	if !mediaSigning.PublicStatic {
+		c.JSON(403, gin.H{"error": "Signed URL required"})
+		return
+	}
+
+	serveBlob(c, filepath.Base(c.Param("filepath")))
+}
+
+// @Summary Serve uploaded file by signed link
+// @Description Serve an uploaded file through a link from a task or upload response. The link expires; request the task again for a fresh one. Accepts the same variant and transform parameters as /static and supports Range requests and the same caching headers.
+// @Tags uploads
+// @Param filepath path string true "File name"
+// @Param exp query int true "Expiry, unix seconds"
+// @Param sig query string true "Signature"
+// @Param variant query string false "Image variant name"
+// @Param w query int false "Transform: width in pixels"
+// @Param h query int false "Transform: height in pixels"
+// @Param fit query string false "Transform: contain (default), cover or fill"
+// @Param q query int false "Transform: JPEG quality 1-100 (default 85)"
+// @Param format query string false "Transform: jpeg, png or webp (lossless); chosen from the Accept header when omitted"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /media/{filepath} [get]
+func serveMedia(c *gin.Context) {
+	fileName := filepath.Base(c.Param("filepath"))
+
+	var exp int64
+	if _, err := fmt.Sscanf(c.Query("exp"), "%d", &exp); err != nil {
+		c.JSON(403, gin.H{"error": "Invalid signature"})
+		return
+	}
+	expected := mediaSignature(fileName, exp)
+	if !hmac.Equal([]byte(c.Query("sig")), []byte(expected)) {
+		c.JSON(403, gin.H{"error": "Invalid signature"})
+		return
+	}
+	if time.Now().Unix() > exp {
+		c.JSON(403, gin.H{"error": "Link expired"})
+		return
+	}
+
+	serveBlob(c, fileName)
+}
+
+func serveBlob(c *gin.Context, fileName string) {
+	if fileName == "/" || fileName == "." {
+		c.JSON(404, gin.H{"error": "File not found"})
+		return
+	}
+
+	transform, err := parseImageTransform(c, fileName)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	isImage := strings.HasPrefix(mime.TypeByExtension(filepath.Ext(fileName)), "image/")
+	if isImage {
+		c.Header("Vary", "Accept")
+	}
+	if transform != nil {
+		serveTransformed(c, fileName, *transform)
+		return
+	}
+
+	if name := c.Query("variant"); name != "" && findImageVariant(name) != nil {
+		variantName := variantFileName(fileName, name)
+		if _, err := blobStore.Stat(variantName); err == nil {
+			fileName = variantName
+		}
+	}
+
+	// A stored format the client cannot display (WebP on old devices) is
+	// converted instead of sent as-is
+	accept := c.GetHeader("Accept")
+	if q, _ := acceptQuality(accept, mime.TypeByExtension(filepath.Ext(fileName))); isImage && q <= 0 {
+		format := negotiateImageFormat(accept, fileName)
+		if q, _ := acceptQuality(accept, "image/"+format); q > 0 {
+			serveTransformed(c, fileName, imageTransform{Fit: "contain", Quality: 85, Format: format})
+			return
+		}
+	}
+
+	info, err := blobStore.Stat(fileName)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "File not found"})
+		return
+	}
+	blob, err := blobStore.Open(fileName)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "File not found"})
+		return
+	}
+	defer blob.Close()
+
+	// Content-addressed blobs get touched on every duplicate upload, so
+	// the record's creation time is the stable Last-Modified
+	modTime := info.ModTime
+	etag := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	dbMutex.RLock()
+	if upload := findUploadByFile(uploadMainFile(fileName)); upload != nil {
+		if fileName == uploadMainFile(fileName) {
+			if upload.ContentType != "" {
+				c.Header("Content-Type", upload.ContentType)
+			}
+			if upload.Hash != "" {
+				etag = upload.Hash
+			}
+		}
+		modTime = upload.CreatedAt
+	}
+	dbMutex.RUnlock()
+
+	c.Header("ETag", `"`+etag+`"`)
+	c.Header("Cache-Control", mediaCacheControl(isContentAddressed(fileName)))
+	http.ServeContent(c.Writer, c.Request, fileName, modTime, blob)
+}
+
+// mediaCacheControl is the Cache-Control for served uploads. Content-addressed
+// files never change under their name and may be cached for a year; others
+// must be revalidated. Behind signed links shared caches are kept out.
+func mediaCacheControl(immutable bool) string {
+	scope := "public"
+	if !mediaSigning.PublicStatic {
+		scope = "private"
+	}
+	if immutable {
+		return scope + ", max-age=31536000, immutable"
+	}
+	return scope + ", no-cache"
+}
+
+// isContentAddressed reports whether a blob (or one of its variants) is
+// named by the SHA-256 of its content
+func isContentAddressed(fileName string) bool {
+	main := uploadMainFile(fileName)
+	hash := strings.TrimSuffix(main, filepath.Ext(main))
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func serveTransformed(c *gin.Context, fileName string, t imageTransform) {
+	if _, err := blobStore.Stat(fileName); err != nil {
+		c.JSON(404, gin.H{"error": "File not found"})
+		return
+	}
+
+	path, err := transformImage(fileName, t)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Image transform failed: " + err.Error()})
+		return
+	}
+	// Keep the file open so a concurrent eviction cannot pull it away
+	cached, err := os.Open(path)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer cached.Close()
+	info, err := cached.Stat()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("ETag", `"`+strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+`"`)
+	c.Header("Cache-Control", mediaCacheControl(isContentAddressed(fileName)))
+	c.Header("Content-Type", "image/"+t.Format)
+	http.ServeContent(c.Writer, c.Request, path, info.ModTime(), cached)
+}
+
+// Category handlers
+
+// @Summary Create a new category
+// @Description Create a new category with the provided data
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Param category body Category true "Category data"
+// @Success 201 {object} Category
+// @Failure 400 {object} map[string]string
+// @Router /categories [post]
+func createCategory(c *gin.Context) {
+	var category Category
+	if err := c.ShouldBindJSON(&category); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if category.Workflow != nil {
+		if err := validateWorkflow(*category.Workflow); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	dbMutex.Lock()
+	category.ID = uuid.New().String()
+	db.Categories = append(db.Categories, category)
+	saveDatabase()
+	dbMutex.Unlock()
+
+	c.JSON(201, category)
+}
+
+// @Summary Get all categories
+// @Description Get a list of all categories
+// @Tags categories
+// @Produce json
+// @Success 200 {array} Category
+// @Router /categories [get]
+func getCategories(c *gin.Context) {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+	c.JSON(200, db.Categories)
+}
+
+// @Summary Get category with tasks
+// @Description Get a single category by its ID with all its tasks
+// @Tags categories
+// @Produce json
+// @Param id path string true "Category ID"
+// @Param assignee query string false "Only tasks assigned to this user ID"
+// @Param order query string false "Set to priority to order by priority, due date, then position"
+// @Success 200 {object} CategoryWithTasksResponse
+// @Failure 404 {object} map[string]string
+// @Router /categories/{id} [get]
+func getCategoryWithTasks(c *gin.Context) {
+	id := c.Param("id")
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	cat := findCategoryByID(id)
+	if cat == nil {
+		c.JSON(404, gin.H{"error": "Category not found"})
+		return
+	}
+
+	// Get all tasks for this category
+	tasks := getTasksByCategoryID(id, false)
+	sortTasks(tasks, c.Query("order"))
+	assignee := c.Query("assignee")
+
+	// Convert tasks to response format
+	var taskResponses []TaskResponse
+	for _, task := range tasks {
+		if assignee != "" && !isAssigned(task, assignee) {
+			continue
+		}
+		taskResponses = append(taskResponses, convertToTaskResponse(task))
+	}
+
+	response := CategoryWithTasksResponse{
+		ID:        cat.ID,
+		Data:      cat.Data,
+		TagCounts: tagCountsForTasks(tasks),
+		Tasks:     taskResponses,
+	}
+
+	c.JSON(200, response)
+}
+
+// @Summary Update category
+// @Description Update an existing category by ID
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Param id path string true "Category ID"
+// @Param category body Category true "Updated category data"
+// @Success 200 {object} Category
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /categories/{id} [put]
+func updateCategory(c *gin.Context) {
+	id := c.Param("id")
+
+	var input Category
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	cat := findCategoryByID(id)
+	if cat == nil {
+		c.JSON(404, gin.H{"error": "Category not found"})
+		return
+	}
+
+	cat.Data = input.Data
+	saveDatabase()
+
+	c.JSON(200, cat)
+}
+
+// @Summary Delete category
+// @Description Delete a category by ID
+// @Tags categories
+// @Produce json
+// @Param id path string true "Category ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /categories/{id} [delete]
+func deleteCategory(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if !deleteCategoryByID(id) {
+		c.JSON(404, gin.H{"error": "Category not found"})
+		return
+	}
+
+	saveDatabase()
+	c.JSON(200, gin.H{"message": "Category deleted"})
+}
+
+// @Summary Get category workflow
+// @Description Get the task state machine used by a category (the default workflow if none is configured)
+// @Tags categories
+// @Produce json
+// @Param id path string true "Category ID"
+// @Success 200 {object} Workflow
+// @Failure 404 {object} map[string]string
+// @Router /categories/{id}/workflow [get]
+func getCategoryWorkflow(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	if findCategoryByID(id) == nil {
+		c.JSON(404, gin.H{"error": "Category not found"})
+		return
+	}
+
+	c.JSON(200, workflowForCategory(id))
+}
+
+// @Summary Update category workflow
+// @Description Replace the task state machine of a category. Tasks in states that no longer exist are moved to the initial state.
+// @Tags categories
+// @Accept json
+// @Produce json
+// @Param id path string true "Category ID"
+// @Param workflow body Workflow true "Workflow definition"
+// @Success 200 {object} Workflow
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /categories/{id}/workflow [put]
+func updateCategoryWorkflow(c *gin.Context) {
+	id := c.Param("id")
+
+	var input Workflow
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := validateWorkflow(input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	cat := findCategoryByID(id)
+	if cat == nil {
+		c.JSON(404, gin.H{"error": "Category not found"})
+		return
+	}
+
+	cat.Workflow = &input
+
+	by := currentUserID(c)
+	for i := range db.Tasks {
+		task := &db.Tasks[i]
+		if task.CategoryID != id {
+			continue
+		}
+		if !input.hasState(task.Status) {
+			setTaskStatus(task, input.Initial, by)
+		}
+		task.IsSuccess = input.isSuccess(task.Status)
+	}
+
+	saveDatabase()
+	c.JSON(200, input)
+}
+
+// @Summary Get tag counts of a category
+// @Description Get how many active tasks of a category carry each tag
+// @Tags categories
+// @Produce json
+// @Param id path string true "Category ID"
+// @Success 200 {array} TagCount
+// @Failure 404 {object} map[string]string
+// @Router /categories/{id}/tags [get]
+func getCategoryTagCounts(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	if findCategoryByID(id) == nil {
+		c.JSON(404, gin.H{"error": "Category not found"})
+		return
+	}
+
+	c.JSON(200, tagCountsForTasks(getTasksByCategoryID(id, false)))
+}
+
+// Task handlers
+
+// @Summary Create a new task
+// @Description Create a new task with category, name, and optional position
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param task body object{category_id=string,name=string,is_success=bool,price=number,position=int,priority=string,due_at=string,auto_complete=bool} true "Task data"
+// @Success 201 {object} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Router /tasks [post]
+func createTask(c *gin.Context) {
+	var input struct {
+		CategoryID   string     `json:"category_id"`
+		Name         string     `json:"name"`
+		IsSuccess    bool       `json:"is_success"`
+		Price        *float32   `json:"price"`
+		Position     *int       `json:"position"`
+		Priority     string     `json:"priority"`
+		DueAt        *time.Time `json:"due_at"`
+		AutoComplete bool       `json:"auto_complete"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Priority == "" {
+		input.Priority = "normal"
+	}
+	if !validPriority(input.Priority) {
+		c.JSON(400, gin.H{"error": "priority must be one of urgent, high, normal, low"})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := Task{
+		ID:           uuid.New().String(),
+		CategoryID:   input.CategoryID,
+		Name:         input.Name,
+		Status:       workflowForCategory(input.CategoryID).Initial,
+		Price:        input.Price,
+		Priority:     input.Priority,
+		DueAt:        input.DueAt,
+		AutoComplete: input.AutoComplete,
+	}
+	setTaskSuccess(&task, input.IsSuccess, currentUserID(c))
+
+	if input.Position == nil {
+		maxPos := -1
+		for _, t := range db.Tasks {
+			if t.DeletedAt == nil && t.Position > maxPos {
+				maxPos = t.Position
+			}
+		}
+		task.Position = maxPos + 1
+	} else {
+		task.Position = *input.Position
+		for i := range db.Tasks {
+			if db.Tasks[i].DeletedAt == nil && db.Tasks[i].Position >= *input.Position {
+				db.Tasks[i].Position++
+			}
+		}
+	}
+
+	db.Tasks = append(db.Tasks, task)
+	recordTaskChange(task, "created")
+	saveDatabase()
+
+	response := convertToTaskResponse(task)
+	c.JSON(201, response)
+}
+
+// @Summary Get all tasks
+// @Description Get a list of all active tasks ordered by position (pinned tasks first)
+// @Tags tasks
+// @Produce json
+// @Param order query string false "Set to priority to order by priority, due date, then position"
+// @Param tags query string false "Comma-separated tag IDs to filter by"
+// @Param tag_match query string false "any (default) or all"
+// @Success 200 {array} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Router /tasks [get]
+func getTasks(c *gin.Context) {
+	var tagIDs []string
+	for _, tagID := range strings.Split(c.Query("tags"), ",") {
+		if tagID = strings.TrimSpace(tagID); tagID != "" {
+			tagIDs = append(tagIDs, tagID)
+		}
+	}
+
+	tagMatch := c.DefaultQuery("tag_match", "any")
+	if tagMatch != "any" && tagMatch != "all" {
+		c.JSON(400, gin.H{"error": "tag_match must be any or all"})
+		return
+	}
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	var responses []TaskResponse
+	var activeTasks []Task
+
+	for _, task := range db.Tasks {
+		if task.DeletedAt == nil && matchesTags(task, tagIDs, tagMatch == "all") {
+			activeTasks = append(activeTasks, task)
+		}
+	}
+
+	sortTasks(activeTasks, c.Query("order"))
+
+	for _, task := range activeTasks {
+		responses = append(responses, convertToTaskResponse(task))
+	}
+
+	c.JSON(200, responses)
+}
+
+// @Summary Get deleted tasks
+// @Description Get a list of all soft-deleted tasks
+// @Tags tasks
+// @Produce json
+// @Success 200 {array} TaskResponse
+// @Router /tasks/deleted [get]
+func getDeletedTasks(c *gin.Context) {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	var responses []TaskResponse
+	for _, task := range db.Tasks {
+		if task.DeletedAt != nil {
+			responses = append(responses, convertToTaskResponse(task))
+		}
+	}
+
+	c.JSON(200, responses)
+}
+
+// @Summary Get task by ID
+// @Description Get a single task by its ID
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} TaskResponse
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id} [get]
+func getTask(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// @Summary Update task
+// @Description Update an existing task. priority, due_at and auto_complete are kept when omitted; send "due_at": null to clear the due date.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param task body object{category_id=string,name=string,is_success=bool,price=number,priority=string,due_at=string,auto_complete=bool} true "Updated task data"
+// @Success 200 {object} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id} [put]
+func updateTask(c *gin.Context) {
+	id := c.Param("id")
+
+	var input struct {
+		CategoryID   string          `json:"category_id"`
+		Name         string          `json:"name"`
+		IsSuccess    bool            `json:"is_success"`
+		Price        *float32        `json:"price"`
+		Priority     *string         `json:"priority"`
+		DueAt        json.RawMessage `json:"due_at"`
+		AutoComplete *bool           `json:"auto_complete"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Priority != nil && !validPriority(*input.Priority) {
+		c.JSON(400, gin.H{"error": "priority must be one of urgent, high, normal, low"})
+		return
+	}
+
+	// due_at is left alone when omitted and cleared with an explicit null
+	var dueAt *time.Time
+	if len(input.DueAt) > 0 {
+		if err := json.Unmarshal(input.DueAt, &dueAt); err != nil {
+			c.JSON(400, gin.H{"error": "due_at must be an RFC 3339 time or null"})
+			return
+		}
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	if input.Priority != nil {
+		task.Priority = *input.Priority
+	}
+	if input.AutoComplete != nil {
+		task.AutoComplete = *input.AutoComplete
+	}
+	if len(input.DueAt) > 0 {
+		task.DueAt = dueAt
+	}
+
+	by := currentUserID(c)
+	task.CategoryID = input.CategoryID
+	task.Name = input.Name
+	task.Price = input.Price
+
+	// A task moved to a category with a different workflow restarts there
+	wf := workflowForCategory(task.CategoryID)
+	if !wf.hasState(task.Status) {
+		setTaskStatus(task, wf.Initial, by)
+	}
+	setTaskSuccess(task, input.IsSuccess, by)
+
+	recordTaskChange(*task, "updated")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// @Summary Task change feed
+// @Description Changes to tasks after the given sequence number, oldest first. Poll with since set to the latest value of the previous response. Urgent tasks are flagged with urgent; urgent=true returns only those. When since is ahead of latest the server has restarted and the whole feed is returned.
+// @Tags tasks
+// @Produce json
+// @Param since query int false "Last sequence number seen"
+// @Param urgent query bool false "Only changes to urgent tasks"
+// @Success 200 {object} ChangeFeed
+// @Failure 400 {object} map[string]string
+// @Router /tasks/changes [get]
+func getTaskChanges(c *gin.Context) {
+	var since int64
+	if raw := c.Query("since"); raw != "" {
+		if _, err := fmt.Sscanf(raw, "%d", &since); err != nil {
+			c.JSON(400, gin.H{"error": "since must be a number"})
+			return
+		}
+	}
+	urgentOnly := c.Query("urgent") == "true"
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	if since > taskChanges.seq {
+		since = 0
+	}
+	feed := ChangeFeed{Latest: taskChanges.seq, Changes: []TaskChange{}}
+	for _, change := range taskChanges.entries {
+		if change.Seq > since && (!urgentOnly || change.Urgent) {
+			feed.Changes = append(feed.Changes, change)
+		}
+	}
+	c.JSON(200, feed)
+}
+
+// @Summary Update task position
+// @Description Change the position of a task in the list
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param position body object{position=int} true "New position"
+// @Success 200 {object} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/position [put]
+func updateTaskPosition(c *gin.Context) {
+	id := c.Param("id")
+
+	var input struct {
+		Position int `json:"position"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	oldPos := task.Position
+	newPos := input.Position
+
+	if oldPos != newPos {
+		if oldPos < newPos {
+			for i := range db.Tasks {
+				if db.Tasks[i].DeletedAt == nil && db.Tasks[i].Position > oldPos && db.Tasks[i].Position <= newPos {
+					db.Tasks[i].Position--
+				}
+			}
+		} else {
+			for i := range db.Tasks {
+				if db.Tasks[i].DeletedAt == nil && db.Tasks[i].Position >= newPos && db.Tasks[i].Position < oldPos {
+					db.Tasks[i].Position++
+				}
+			}
+		}
+		task.Position = newPos
+	}
+
+	recordTaskChange(*task, "moved")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// @Summary Soft delete task
+// @Description Soft delete a task (can be restored later)
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id} [delete]
+func deleteTask(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	now := time.Now()
+	task.DeletedAt = &now
+
+	for i := range db.Tasks {
+		if db.Tasks[i].DeletedAt == nil && db.Tasks[i].Position > task.Position {
+			db.Tasks[i].Position--
+		}
+	}
+
+	recordTaskChange(*task, "deleted")
+	saveDatabase()
+	c.JSON(200, gin.H{"message": "Task deleted (soft delete)"})
+}
+
+// @Summary Restore deleted task
+// @Description Restore a soft-deleted task
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/restore [put]
+func restoreTask(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, true)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	if task.DeletedAt == nil {
+		c.JSON(400, gin.H{"error": "Task is not deleted"})
+		return
+	}
+
+	maxPos := -1
+	for _, t := range db.Tasks {
+		if t.DeletedAt == nil && t.Position > maxPos {
+			maxPos = t.Position
+		}
+	}
+	task.Position = maxPos + 1
+	task.DeletedAt = nil
+
+	recordTaskChange(*task, "restored")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, gin.H{"message": "Task restored", "task": response})
+}
+
+// @Summary Permanently delete task
+// @Description Permanently delete a task and all its items. Files no other item uses are left to the upload garbage collector.
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/permanent [delete]
+func permanentDeleteTask(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, true)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	items := getTaskItemsByID(id)
+	for _, item := range items {
+		deleteTaskItemUpload(item)
+	}
+
+	newItems := []TaskItem{}
+	for _, item := range db.TaskItems {
+		if item.TaskID != id {
+			newItems = append(newItems, item)
+		}
+	}
+	db.TaskItems = newItems
+
+	recordTaskChange(*task, "purged")
+	deleteTaskByID(id)
+	saveDatabase()
+
+	c.JSON(200, gin.H{"message": "Task permanently deleted"})
+}
+
+// @Summary Mark task as success
+// @Description Update task success status and price. is_success=true moves the task to its workflow's success state, false back to the initial state.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param success body object{is_success=bool,price=number} true "Success status and price"
+// @Success 200 {object} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/success [put]
+func markTaskSuccess(c *gin.Context) {
+	id := c.Param("id")
+
+	var input struct {
+		IsSuccess bool     `json:"is_success"`
+		Price     *float32 `json:"price"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	setTaskSuccess(task, input.IsSuccess, currentUserID(c))
+	task.Price = input.Price
+
+	recordTaskChange(*task, "status")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// @Summary Get allowed transitions
+// @Description Get the current status of a task and the statuses it may move to
+// @Tags tasks
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/transitions [get]
+func getTaskTransitions(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	wf := workflowForCategory(task.CategoryID)
+	c.JSON(200, gin.H{"status": task.Status, "allowed": wf.allowedFrom(task.Status)})
+}
+
+// @Summary Transition task status
+// @Description Move a task to another workflow status. Moves not allowed by the category workflow are rejected.
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param X-User-ID header string false "User making the change"
+// @Param transition body object{status=string} true "Target status"
+// @Success 200 {object} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]interface{}
+// @Router /tasks/{id}/transition [post]
+func transitionTask(c *gin.Context) {
+	id := c.Param("id")
+
+	var input struct {
+		Status string `json:"status"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	wf := workflowForCategory(task.CategoryID)
+	if !wf.hasState(input.Status) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Unknown status %q", input.Status)})
+		return
+	}
+
+	if !wf.canTransition(task.Status, input.Status) {
+		c.JSON(409, gin.H{
+			"error":   fmt.Sprintf("Cannot move task from %q to %q", task.Status, input.Status),
+			"allowed": wf.allowedFrom(task.Status),
+		})
+		return
+	}
+
+	setTaskStatus(task, input.Status, currentUserID(c))
+	recordTaskChange(*task, "status")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// @Summary Update task priority
+// @Description Set the priority of a task (urgent, high, normal or low)
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param priority body object{priority=string} true "New priority"
+// @Success 200 {object} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/priority [put]
+func updateTaskPriority(c *gin.Context) {
+	id := c.Param("id")
+
+	var input struct {
+		Priority string `json:"priority"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !validPriority(input.Priority) {
+		c.JSON(400, gin.H{"error": "priority must be one of urgent, high, normal, low"})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	task.Priority = input.Priority
+	recordTaskChange(*task, "priority")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// @Summary Pin or unpin task
+// @Description Pinned tasks are listed before all other tasks
+// @Tags tasks
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param pin body object{pinned=bool} true "Pin state"
+// @Success 200 {object} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/pin [put]
+func pinTask(c *gin.Context) {
+	id := c.Param("id")
+
+	var input struct {
+		Pinned bool `json:"pinned"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	task.Pinned = input.Pinned
+	recordTaskChange(*task, "pinned")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// Assignment handlers
+
+// @Summary Assign users to task
+// @Description Add one or more assignees to a task
+// @Tags assignments
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param X-User-ID header string false "User making the change"
+// @Param assignees body object{user_ids=[]string} true "User IDs to assign"
+// @Success 200 {object} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/assignees [post]
+func assignTask(c *gin.Context) {
+	id := c.Param("id")
+
+	var input struct {
+		UserIDs []string `json:"user_ids"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(input.UserIDs) == 0 {
+		c.JSON(400, gin.H{"error": "user_ids is required"})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	by := currentUserID(c)
+	for _, userID := range input.UserIDs {
+		if userID != "" {
+			assignUser(task, userID, by)
+		}
+	}
+
+	recordTaskChange(*task, "assigned")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// @Summary Reassign task
+// @Description Replace the assignees of a task, recording every handoff
+// @Tags assignments
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param X-User-ID header string false "User making the change"
+// @Param assignees body object{user_ids=[]string} true "New set of user IDs"
+// @Success 200 {object} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/assignees [put]
+func reassignTask(c *gin.Context) {
+	id := c.Param("id")
+
+	var input struct {
+		UserIDs []string `json:"user_ids"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	keep := map[string]bool{}
+	for _, userID := range input.UserIDs {
+		if userID != "" {
+			keep[userID] = true
+		}
+	}
+
+	by := currentUserID(c)
+	for _, userID := range append([]string{}, task.Assignees...) {
+		if !keep[userID] {
+			unassignUser(task, userID, by)
+		}
+	}
+	for _, userID := range input.UserIDs {
+		if userID != "" {
+			assignUser(task, userID, by)
+		}
+	}
+
+	recordTaskChange(*task, "assigned")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// @Summary Unassign user from task
+// @Description Remove a single assignee from a task
+// @Tags assignments
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param user_id path string true "User ID"
+// @Param X-User-ID header string false "User making the change"
+// @Success 200 {object} TaskResponse
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/assignees/{user_id} [delete]
+func unassignTask(c *gin.Context) {
+	id := c.Param("id")
+	userID := c.Param("user_id")
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	if !unassignUser(task, userID, currentUserID(c)) {
+		c.JSON(404, gin.H{"error": "User is not assigned to this task"})
+		return
+	}
+
+	recordTaskChange(*task, "assigned")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// @Summary Get task assignment history
+// @Description Get all assign/unassign events of a task, oldest first
+// @Tags assignments
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {array} AssignmentEvent
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/assignments [get]
+func getTaskAssignments(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	task := findTaskByID(id, true)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	history := task.AssignmentHistory
+	if history == nil {
+		history = []AssignmentEvent{}
+	}
+
+	c.JSON(200, history)
+}
+
+// @Summary Get my tasks
+// @Description Get active tasks assigned to the current user (X-User-ID header)
+// @Tags assignments
+// @Produce json
+// @Param X-User-ID header string true "Current user ID"
+// @Param order query string false "Set to priority to order by priority, due date, then position"
+// @Success 200 {array} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Router /me/tasks [get]
+func getMyTasks(c *gin.Context) {
+	userID := currentUserID(c)
+	if userID == "" {
+		c.JSON(400, gin.H{"error": "X-User-ID header is required"})
+		return
+	}
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	var myTasks []Task
+	for _, task := range db.Tasks {
+		if task.DeletedAt == nil && isAssigned(task, userID) {
+			myTasks = append(myTasks, task)
+		}
+	}
+
+	sortTasks(myTasks, c.Query("order"))
+
+	responses := []TaskResponse{}
+	for _, task := range myTasks {
+		responses = append(responses, convertToTaskResponse(task))
+	}
+
+	c.JSON(200, responses)
+}
+
+// Tag handlers
+
+// @Summary Create a new tag
+// @Description Create a tag with a name and a #RRGGBB color
+// @Tags tags
+// @Accept json
+// @Produce json
+// @Param tag body object{name=string,color=string} true "Tag data"
+// @Success 201 {object} Tag
+// @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /tags [post]
+func createTag(c *gin.Context) {
+	var tag Tag
+	if err := c.ShouldBindJSON(&tag); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	tag.Name = strings.TrimSpace(tag.Name)
+	if tag.Name == "" {
+		c.JSON(400, gin.H{"error": "name is required"})
+		return
+	}
+	if tag.Color == "" {
+		tag.Color = "#9E9E9E"
+	}
+	if !validTagColor(tag.Color) {
+		c.JSON(400, gin.H{"error": "color must be in #RRGGBB format"})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if findTagByName(tag.Name) != nil {
+		c.JSON(409, gin.H{"error": "Tag with this name already exists"})
+		return
+	}
+
+	tag.ID = uuid.New().String()
+	db.Tags = append(db.Tags, tag)
+	saveDatabase()
+
+	c.JSON(201, tag)
+}
+
+// @Summary Get all tags
+// @Description Get a list of all tags
+// @Tags tags
+// @Produce json
+// @Success 200 {array} Tag
+// @Router /tags [get]
+func getTags(c *gin.Context) {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	tags := db.Tags
+	if tags == nil {
+		tags = []Tag{}
+	}
+	c.JSON(200, tags)
+}
+
+// @Summary Get tag by ID
+// @Description Get a single tag by its ID
+// @Tags tags
+// @Produce json
+// @Param id path string true "Tag ID"
+// @Success 200 {object} Tag
+// @Failure 404 {object} map[string]string
+// @Router /tags/{id} [get]
+func getTag(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	tag := findTagByID(id)
+	if tag == nil {
+		c.JSON(404, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	c.JSON(200, tag)
+}
+
+// @Summary Update tag
+// @Description Rename or recolor a tag
+// @Tags tags
+// @Accept json
+// @Produce json
+// @Param id path string true "Tag ID"
+// @Param tag body object{name=string,color=string} true "Updated tag data"
+// @Success 200 {object} Tag
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Router /tags/{id} [put]
+func updateTag(c *gin.Context) {
+	id := c.Param("id")
+
+	var input Tag
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		c.JSON(400, gin.H{"error": "name is required"})
+		return
+	}
+	if !validTagColor(input.Color) {
+		c.JSON(400, gin.H{"error": "color must be in #RRGGBB format"})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	tag := findTagByID(id)
+	if tag == nil {
+		c.JSON(404, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	if other := findTagByName(input.Name); other != nil && other.ID != id {
+		c.JSON(409, gin.H{"error": "Tag with this name already exists"})
+		return
+	}
+
+	tag.Name = input.Name
+	tag.Color = input.Color
+	saveDatabase()
+
+	c.JSON(200, tag)
+}
+
+// @Summary Delete tag
+// @Description Delete a tag and detach it from all tasks
+// @Tags tags
+// @Produce json
+// @Param id path string true "Tag ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tags/{id} [delete]
+func deleteTag(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	found := false
+	for i := range db.Tags {
+		if db.Tags[i].ID == id {
+			db.Tags = append(db.Tags[:i], db.Tags[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	for i := range db.Tasks {
+		removeTagFromTask(&db.Tasks[i], id)
+	}
+
+	saveDatabase()
+	c.JSON(200, gin.H{"message": "Tag deleted"})
+}
+
+func removeTagFromTask(task *Task, tagID string) bool {
+	for i, id := range task.TagIDs {
+		if id == tagID {
+			task.TagIDs = append(task.TagIDs[:i], task.TagIDs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// @Summary Add tags to task
+// @Description Attach one or more existing tags to a task
+// @Tags tags
+// @Accept json
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param tags body object{tag_ids=[]string} true "Tag IDs to attach"
+// @Success 200 {object} TaskResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/tags [post]
+func addTaskTags(c *gin.Context) {
+	id := c.Param("id")
+
+	var input struct {
+		TagIDs []string `json:"tag_ids"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	for _, tagID := range input.TagIDs {
+		if findTagByID(tagID) == nil {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Tag %s not found", tagID)})
+			return
+		}
+	}
+
+	for _, tagID := range input.TagIDs {
+		if !hasTag(*task, tagID) {
+			task.TagIDs = append(task.TagIDs, tagID)
+		}
+	}
+
+	recordTaskChange(*task, "tagged")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// @Summary Remove tag from task
+// @Description Detach a tag from a task
+// @Tags tags
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param tag_id path string true "Tag ID"
+// @Success 200 {object} TaskResponse
+// @Failure 404 {object} map[string]string
+// @Router /tasks/{id}/tags/{tag_id} [delete]
+func removeTaskTag(c *gin.Context) {
+	id := c.Param("id")
+	tagID := c.Param("tag_id")
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	task := findTaskByID(id, false)
+	if task == nil {
+		c.JSON(404, gin.H{"error": "Task not found"})
+		return
+	}
+
+	if !removeTagFromTask(task, tagID) {
+		c.JSON(404, gin.H{"error": "Tag is not attached to this task"})
+		return
+	}
+
+	recordTaskChange(*task, "tagged")
+	saveDatabase()
+
+	response := convertToTaskResponse(*task)
+	c.JSON(200, response)
+}
+
+// TaskItem handlers
+
+// @Summary Create task item
+// @Description Create a new task item. Pass upload_id to attach a registered upload; data is then set to its URL. Checklist items always start open; done, done_by and done_at are ignored, use PUT /task-items/{id}/check.
+// @Tags task-items
+// @Accept json
+// @Produce json
+// @Param item body TaskItem true "Task item data"
+// @Success 201 {object} TaskItem
+// @Failure 400 {object} map[string]string
+// @Router /task-items [post]
+func createTaskItem(c *gin.Context) {
+	var item TaskItem
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	// Ticking off goes through checkTaskItem, which records who and when
+	item.Done = false
+	item.DoneBy = ""
+	item.DoneAt = nil
+
+	dbMutex.Lock()
+	if err := linkTaskItemUpload(&item); err != nil {
+		dbMutex.Unlock()
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	retainUpload(item.UploadID)
+
+	item.ID = uuid.New().String()
+	item.Time = time.Now()
+
+	maxPos := 0
+	for _, existingItem := range db.TaskItems {
+		if existingItem.TaskID == item.TaskID && existingItem.Position > maxPos {
+			maxPos = existingItem.Position
+		}
+	}
+	item.Position = maxPos + 1
+
+	db.TaskItems = append(db.TaskItems, item)
+	saveDatabase()
+	item = withUpload(item)
+	dbMutex.Unlock()
+
+	c.JSON(201, item)
+}
+
+// @Summary Upload and attach files to a task
+// @Description Upload one or more files (repeat the files field) and add them to the task as image, audio or video items, positioned in the order sent. The kind is detected from each file's content. Either all files are attached or none: on any failure the files stored so far are removed again. Images that look like one already on the task come back with similar and warning set; they are attached anyway.
+// @Tags task-items
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path string true "Task ID"
+// @Param X-User-ID header string false "Uploader, charged against the user quota"
+// @Param files formData file true "Files to attach"
+// @Success 201 {array} TaskItem
+// @Failure 400 {object} UploadResponse
+// @Failure 404 {object} UploadResponse
+// @Failure 413 {object} UploadResponse
+// @Failure 415 {object} UploadResponse
+// @Failure 500 {object} UploadResponse
+// @Router /tasks/{id}/attachments [post]
+func attachTaskFiles(c *gin.Context) {
+	taskID := c.Param("id")
+
+	dbMutex.RLock()
+	task := findTaskByID(taskID, false)
+	var categoryID string
+	if task != nil {
+		categoryID = task.CategoryID
+	}
+	dbMutex.RUnlock()
+
+	if task == nil {
+		(&uploadFailure{404, "", "Vazifa topilmadi"}).respond(c)
+		return
+	}
+
+	limitUploadBody(c, maxUploadBody())
+	form, err := c.MultipartForm()
+	if err != nil {
+		formFileFailure(err, "Fayllarni olishda xatolik: ").respond(c)
+		return
+	}
+	headers := form.File["files"]
+	if len(headers) == 0 {
+		(&uploadFailure{400, errCodeMissingFile, "Fayllarni olishda xatolik: files maydoni bo'sh"}).respond(c)
+		return
+	}
+
+	ownerID := currentUserID(c)
+	if failure := preCheckQuota(ownerID, categoryID); failure != nil {
+		failure.respond(c)
+		return
+	}
+
+	var stored []UploadData
+	kinds := make([]string, 0, len(headers))
+	warnings := make([]string, 0, len(headers))
+	fail := func(header *multipart.FileHeader, failure *uploadFailure) {
+		discardUploads(stored)
+		failure.Message = header.Filename + ": " + failure.Message
+		failure.respond(c)
+	}
+
+	for _, header := range headers {
+		data, kind, failure := processUploadFile(header)
+		if failure != nil {
+			fail(header, failure)
+			return
+		}
+		if failure := recordUpload(&data, ownerID, categoryID); failure != nil {
+			fail(header, failure)
+			return
+		}
+		// Compared with the task as it was before this request
+		warning := nearDuplicateWarning(&data, taskID)
+		stored = append(stored, data)
+		kinds = append(kinds, kind)
+		warnings = append(warnings, warning)
+	}
+
+	dbMutex.Lock()
+	// The task or an upload may have gone while the files were processed
+	var missing *uploadFailure
+	if findTaskByID(taskID, false) == nil {
+		missing = &uploadFailure{404, "", "Vazifa topilmadi"}
+	}
+	for _, data := range stored {
+		if missing == nil && findUploadByID(data.ID) == nil {
+			missing = &uploadFailure{500, errCodeStorage, "Yuklangan fayl topilmadi: " + data.FileName}
+		}
+	}
+	if missing != nil {
+		dbMutex.Unlock()
+		discardUploads(stored)
+		missing.respond(c)
+		return
+	}
+
+	maxPos := 0
+	for _, existingItem := range db.TaskItems {
+		if existingItem.TaskID == taskID && existingItem.Position > maxPos {
+			maxPos = existingItem.Position
+		}
+	}
+
+	now := time.Now()
+	items := make([]TaskItem, 0, len(stored))
+	for i, data := range stored {
+		item := TaskItem{
+			ID:       uuid.New().String(),
+			TaskID:   taskID,
+			Type:     kinds[i],
+			UploadID: data.ID,
+			Time:     now,
+			Position: maxPos + 1 + i,
+		}
+		linkTaskItemUpload(&item)
+		retainUpload(item.UploadID)
+		db.TaskItems = append(db.TaskItems, item)
+		items = append(items, item)
+	}
+	saveDatabase()
+	items = withUploads(items)
+	dbMutex.Unlock()
+
+	for i := range items {
+		items[i].Similar = stored[i].Similar
+		items[i].Warning = warnings[i]
+	}
+	c.JSON(201, items)
+}
+
+// @Summary Get all task items
+// @Description Get a list of all task items
+// @Tags task-items
+// @Produce json
+// @Success 200 {array} TaskItem
+// @Router /task-items [get]
+func getTaskItems(c *gin.Context) {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+	c.JSON(200, withUploads(db.TaskItems))
+}
+
+// @Summary Get task item by ID
+// @Description Get a single task item by ID
+// @Tags task-items
+// @Produce json
+// @Param id path string true "Task item ID"
+// @Success 200 {object} TaskItem
+// @Failure 404 {object} map[string]string
+// @Router /task-items/{id} [get]
+func getTaskItem(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	item := findTaskItemByID(id)
+	if item == nil {
+		c.JSON(404, gin.H{"error": "Task item not found"})
+		return
+	}
+
+	c.JSON(200, withUpload(*item))
+}
+
+// @Summary Get task items by task ID
+// @Description Get all items for a specific task
+// @Tags task-items
+// @Produce json
+// @Param id path string true "Task ID"
+// @Success 200 {array} TaskItem
+// @Router /tasks/{id}/items [get]
+func getTaskItemsByTaskID(c *gin.Context) {
+	taskID := c.Param("id")
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	items := getTaskItemsByID(taskID)
+	c.JSON(200, withUploads(items))
+}
+
+// @Summary Update task item
+// @Description Update an existing task item. Pass upload_id to attach a registered upload; data is then set to its URL.
+// @Tags task-items
+// @Accept json
+// @Produce json
+// @Param id path string true "Task item ID"
+// @Param item body TaskItem true "Updated task item data"
+// @Success 200 {object} TaskItem
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /task-items/{id} [put]
+func updateTaskItem(c *gin.Context) {
+	id := c.Param("id")
+
+	var input TaskItem
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	item := findTaskItemByID(id)
+	if item == nil {
+		c.JSON(404, gin.H{"error": "Task item not found"})
+		return
+	}
+
+	if err := linkTaskItemUpload(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.UploadID != item.UploadID {
+		// The previous file is left for the garbage collector
+		releaseUpload(item.UploadID)
+		retainUpload(input.UploadID)
+	}
+
+	item.Type = input.Type
+	item.Data = input.Data
+	item.UploadID = input.UploadID
+	item.TaskID = input.TaskID
+
+	saveDatabase()
+	c.JSON(200, withUpload(*item))
+}
+
+// @Summary Delete task item
+// @Description Delete a task item. A file no other item uses is removed by the upload garbage collector once the grace period has passed.
+// @Tags task-items
+// @Produce json
+// @Param id path string true "Task item ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /task-items/{id} [delete]
+func deleteTaskItem(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	item := findTaskItemByID(id)
+	if item == nil {
+		c.JSON(404, gin.H{"error": "Task item not found"})
+		return
+	}
+
+	deleteTaskItemUpload(*item)
+
+	deleteTaskItemByID(id)
+	saveDatabase()
+
+	c.JSON(200, gin.H{"message": "Task item deleted"})
+}
+
+// @Summary Tick off checklist item
+// @Description Mark a checklist task item as done or undone. If the task has auto_complete enabled and every checklist item is done, the task is marked successful.
+// @Tags task-items
+// @Accept json
+// @Produce json
+// @Param id path string true "Task item ID"
+// @Param X-User-ID header string false "User completing the item"
+// @Param check body object{done=bool} true "Completion state"
+// @Success 200 {object} TaskItem
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /task-items/{id}/check [put]
+func checkTaskItem(c *gin.Context) {
+	id := c.Param("id")
+
+	var input struct {
+		Done bool `json:"done"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	item := findTaskItemByID(id)
+	if item == nil {
+		c.JSON(404, gin.H{"error": "Task item not found"})
+		return
+	}
+
+	if item.Type != checklistItemType {
+		c.JSON(400, gin.H{"error": "Only checklist items can be checked"})
+		return
+	}
+
+	by := currentUserID(c)
+	item.Done = input.Done
+	if input.Done {
+		now := time.Now()
+		item.DoneBy = by
+		item.DoneAt = &now
+	} else {
+		item.DoneBy = ""
+		item.DoneAt = nil
+	}
+
+	result := *item
+	if input.Done {
+		autoCompleteTask(item.TaskID, by)
+	}
+
+	saveDatabase()
+	c.JSON(200, result)
+}
+
+// File upload handlers
+
+// uploadFailure is returned by the upload pipeline and rendered as an
+// UploadResponse by the handlers
+type uploadFailure struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (f *uploadFailure) respond(c *gin.Context) {
+	c.JSON(f.Status, UploadResponse{
+		Success:    false,
+		StatusCode: f.Status,
+		ErrorCode:  f.Code,
+		Message:    f.Message,
+	})
+}
+
+// limitUploadBody caps the request body while it is streamed in; reading
+// past the limit fails with *http.MaxBytesError
+func limitUploadBody(c *gin.Context, limit int64) {
+	if limit > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	}
+}
+
+// formFileFailure turns a FormFile error into an upload failure
+func formFileFailure(err error, message string) *uploadFailure {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &uploadFailure{413, errCodeTooLarge, fmt.Sprintf("Fayl hajmi ruxsat etilganidan katta (maksimum %d bayt)", tooLarge.Limit)}
+	}
+	return &uploadFailure{400, errCodeMissingFile, message + err.Error()}
+}
+
+// uploadOwner returns who an upload is charged to: the X-User-ID user and
+// the category_id form field or query parameter
+func uploadOwner(c *gin.Context) (string, string) {
+	return currentUserID(c), c.DefaultPostForm("category_id", c.Query("category_id"))
+}
+
+// preCheckQuota rejects uploads from owners that are already at their quota
+func preCheckQuota(ownerID string, categoryID string) *uploadFailure {
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+	return checkQuota(ownerID, categoryID, 1)
+}
+
+// sniffUpload detects the real content type of an upload from its magic
+// bytes and checks it against the endpoint's allow-list
+func sniffUpload(file multipart.File, fileName string, policy uploadPolicy) (*uploadFormat, *uploadFailure) {
+	file.Seek(0, 0)
+	detected, err := mimetype.DetectReader(file)
+	file.Seek(0, 0)
+	if err != nil {
+		return nil, &uploadFailure{500, errCodeStorage, "Faylni o'qishda xatolik: " + err.Error()}
+	}
+
+	// Walk up the type tree so e.g. x-executable is caught as x-elf
+	for m := detected; m != nil; m = m.Parent() {
+		for _, mime := range executableMIMEs {
+			if m.Is(mime) {
+				return nil, &uploadFailure{415, errCodeExecutable, "Bajariladigan fayllarni yuklash mumkin emas (" + detected.String() + ")"}
+			}
+		}
+	}
+
+	format := policy.find(detected)
+	if format == nil {
+		return nil, &uploadFailure{415, errCodeUnsupportedType, "Fayl turi qo'llab-quvvatlanmaydi: " + detected.String()}
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if policy.StrictExtension && ext != "" && !format.acceptsExtension(ext) {
+		return nil, &uploadFailure{415, errCodeTypeMismatch, fmt.Sprintf("Fayl kengaytmasi (%s) uning tarkibiga (%s) mos emas", ext, detected.String())}
+	}
+
+	return format, nil
+}
+
+// processImageUpload decodes, orients, resizes and re-encodes an uploaded image
+func processImageUpload(file multipart.File, fileName string) (UploadData, *uploadFailure) {
+	detected, failure := sniffUpload(file, fileName, imageUploadPolicy)
+	if failure != nil {
+		return UploadData{}, failure
+	}
+
+	originalExt := strings.ToLower(filepath.Ext(fileName))
+
+	img, format, err := decodeImage(file, detected.Ext)
+	if err != nil {
+		return UploadData{}, &uploadFailure{400, errCodeDecodeFailed, "Rasmni o'qishda xatolik: " + err.Error()}
+	}
+
+	log.Printf("Image decoded successfully. Format: %s, Original ext: %s", format, originalExt)
+
+	// Rotate before resizing; the EXIF block itself is never written back,
+	// so orientation, GPS and camera data are stripped from every format
+	meta := readImageMetadata(file, format)
+	img = applyOrientation(img, meta.Orientation)
+	if meta.HasGPS {
+		log.Println("GPS metadata removed from uploaded image")
+	}
+	phash := perceptualHash(img)
+
+	fileID := uuid.New().String()
+
+	saveExt := detected.Ext
+	contentType := detected.ContentType
+
+	switch imageStorageFormat {
+	case "jpeg":
+		saveExt = ".jpg"
+		contentType = "image/jpeg"
+	case "webp-lossless", "webp-lossy":
+		saveExt = ".webp"
+		contentType = "image/webp"
 	default:
-		opts := &jpeg.Options{Quality: 90}
-		return jpeg.Encode(out, img, opts)
+		if saveExt == ".heic" || saveExt == ".heif" {
+			// No encoder for HEIF, store as JPEG
+			saveExt = ".jpg"
+			contentType = "image/jpeg"
+		}
+	}
+
+	largest := imageVariants[len(imageVariants)-1]
+	resized := uint(img.Bounds().Dx()) > largest.Width
+	if resized {
+		img = resize.Resize(largest.Width, 0, img, resize.Lanczos3)
+		log.Printf("Image resized to %dpx width", largest.Width)
+	}
+
+	tmpPath := uploadTempPath(saveExt)
+
+	// An upright image that fits is kept byte for byte, minus its
+	// metadata, instead of losing quality to another encode
+	var original []byte
+	if saveExt == detected.Ext && !resized && meta.Orientation <= 1 {
+		file.Seek(0, 0)
+		if data, err := ioutil.ReadAll(file); err == nil {
+			original = stripImageMetadata(data, format)
+		}
+	}
+	if original != nil {
+		err = ioutil.WriteFile(tmpPath, original, 0644)
+	} else {
+		err = saveImage(img, tmpPath, saveExt)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return UploadData{}, &uploadFailure{500, errCodeStorage, "Rasmni saqlashda xatolik: " + err.Error()}
+	}
+
+	fileInfo, err := os.Stat(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return UploadData{}, &uploadFailure{500, errCodeStorage, "Fayl ma'lumotlarini olishda xatolik: " + err.Error()}
+	}
+	fileSize := fileInfo.Size()
+
+	hash, err := hashFile(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return UploadData{}, &uploadFailure{500, errCodeStorage, "Fayl ma'lumotlarini olishda xatolik: " + err.Error()}
+	}
+
+	storedName, existed, err := storeContentAddressed(tmpPath, hash, saveExt, contentType)
+	if err != nil {
+		return UploadData{}, &uploadFailure{500, errCodeStorage, "Rasmni saqlashda xatolik: " + err.Error()}
+	}
+	imageURL := blobStore.URL(storedName)
+
+	variants, err := saveImageVariants(img, hash, saveExt, contentType)
+	if err != nil {
+		if !existed {
+			removeUploadFile(imageURL)
+		}
+		return UploadData{}, &uploadFailure{500, errCodeStorage, "Rasm variantlarini saqlashda xatolik: " + err.Error()}
+	}
+
+	return UploadData{
+		ID:          fileID,
+		Size:        fileSize,
+		URL:         imageURL,
+		FileName:    fileName,
+		ContentType: contentType,
+		Hash:        hash,
+		DurationMs:  nil,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		PHash:       phash,
+		Variants:    variants,
+		CapturedAt:  meta.CapturedAt,
+	}, nil
+}
+
+// perceptualHash returns the 64-bit difference hash (dHash) of an image as
+// 16 hex digits. Resized, recompressed or slightly edited copies of a
+// photo differ from it in only a few bits.
+func perceptualHash(img image.Image) string {
+	small := resize.Resize(9, 8, img, resize.Bilinear)
+	b := small.Bounds()
+	gray := func(x, y int) uint32 {
+		r, g, bl, _ := small.At(b.Min.X+x, b.Min.Y+y).RGBA()
+		return (299*r + 587*g + 114*bl) / 1000
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray(x, y) > gray(x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// hashDistance is the number of differing bits between two perceptual
+// hashes, or -1 if either is missing
+func hashDistance(a string, b string) int {
+	var x, y uint64
+	if _, err := fmt.Sscanf(a, "%x", &x); err != nil || len(a) != 16 {
+		return -1
+	}
+	if _, err := fmt.Sscanf(b, "%x", &y); err != nil || len(b) != 16 {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+// findSimilarImages lists image uploads shown by task items in scope whose
+// perceptual hash is within maxDistance of phash, closest first. The scope
+// is a task, or all live tasks of a category when taskID is empty; with
+// neither every task item is searched.
+func findSimilarImages(phash string, taskID string, categoryID string, maxDistance int) []SimilarImage {
+	inScope := func(item TaskItem) bool {
+		if taskID != "" {
+			return item.TaskID == taskID
+		}
+		if categoryID != "" {
+			task := findTaskByID(item.TaskID, false)
+			return task != nil && task.CategoryID == categoryID
+		}
+		return true
+	}
+
+	found := map[string]*SimilarImage{}
+	var order []string
+	for _, item := range db.TaskItems {
+		if item.UploadID == "" || !inScope(item) {
+			continue
+		}
+		if match, ok := found[item.UploadID]; ok {
+			match.TaskItemIDs = append(match.TaskItemIDs, item.ID)
+			continue
+		}
+		upload := findUploadByID(item.UploadID)
+		if upload == nil {
+			continue
+		}
+		distance := hashDistance(phash, upload.PHash)
+		if distance < 0 || distance > maxDistance {
+			continue
+		}
+		found[upload.ID] = &SimilarImage{Upload: *signedUpload(upload), Distance: distance, TaskItemIDs: []string{item.ID}}
+		order = append(order, upload.ID)
+	}
+
+	result := make([]SimilarImage, 0, len(order))
+	for _, id := range order {
+		result = append(result, *found[id])
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Distance < result[j].Distance })
+	return result
+}
+
+// nearDuplicateWarning fills data.Similar with the images on the task that
+// look like the uploaded one and returns the warning for the client, or ""
+func nearDuplicateWarning(data *UploadData, taskID string) string {
+	if taskID == "" || data.PHash == "" {
+		return ""
+	}
+
+	dbMutex.RLock()
+	data.Similar = findSimilarImages(data.PHash, taskID, "", similarImageDistance)
+	dbMutex.RUnlock()
+
+	if len(data.Similar) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Bu vazifada %d ta o'xshash rasm allaqachon bor", len(data.Similar))
+}
+
+// backfillImageHashes computes the perceptual hash of image uploads stored
+// before hashing was added
+func backfillImageHashes() {
+	dbMutex.RLock()
+	var pending []Upload
+	for _, u := range db.Uploads {
+		if u.PHash == "" && strings.HasPrefix(u.ContentType, "image/") {
+			pending = append(pending, u)
+		}
+	}
+	dbMutex.RUnlock()
+
+	hashes := map[string]string{}
+	for _, u := range pending {
+		name, ok := blobNameFromURL(u.URL)
+		if !ok {
+			continue
+		}
+		blob, err := blobStore.Open(name)
+		if err != nil {
+			continue
+		}
+		if file, ok := blob.(multipart.File); ok {
+			if img, _, err := decodeImage(file, strings.ToLower(filepath.Ext(name))); err == nil {
+				hashes[u.ID] = perceptualHash(img)
+			}
+		}
+		blob.Close()
+	}
+	if len(hashes) == 0 {
+		return
+	}
+
+	dbMutex.Lock()
+	for id, phash := range hashes {
+		if upload := findUploadByID(id); upload != nil {
+			upload.PHash = phash
+		}
+	}
+	saveDatabase()
+	dbMutex.Unlock()
+	log.Printf("%d ta rasm uchun perceptual hash hisoblandi", len(hashes))
+}
+
+// hashFile returns the hex SHA-256 of a file
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// processMediaUpload stores an audio or video file as-is, under the
+// extension of its detected content type
+func processMediaUpload(file multipart.File, fileName string, policy uploadPolicy) (UploadData, *uploadFailure) {
+	detected, failure := sniffUpload(file, fileName, policy)
+	if failure != nil {
+		return UploadData{}, failure
+	}
+
+	fileID := uuid.New().String()
+	tmpPath := uploadTempPath(detected.Ext)
+
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return UploadData{}, &uploadFailure{500, errCodeStorage, "Faylni saqlashda xatolik: " + err.Error()}
+	}
+	hasher := sha256.New()
+	fileSize, err := io.Copy(io.MultiWriter(out, hasher), file)
+	out.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return UploadData{}, &uploadFailure{500, errCodeStorage, "Faylni saqlashda xatolik: " + err.Error()}
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	info := probeMedia(file, fileSize, detected.Ext)
+
+	var waveform []float64
+	if strings.HasPrefix(detected.ContentType, "audio/") {
+		if target, ok := transcodeAudio(tmpPath); ok {
+			tmpPath, detected = target.path, &target.format
+			fileSize, hash, info = target.size, target.hash, target.info
+		}
+		waveform = audioWaveform(tmpPath, detected.Ext)
+	}
+	poster, preview := extractVideoPreviews(tmpPath, hash, info)
+
+	storedName, _, err := storeContentAddressed(tmpPath, hash, detected.Ext, detected.ContentType)
+	if err != nil {
+		return UploadData{}, &uploadFailure{500, errCodeStorage, "Faylni saqlashda xatolik: " + err.Error()}
+	}
+
+	return UploadData{
+		ID:          fileID,
+		Size:        fileSize,
+		URL:         blobStore.URL(storedName),
+		FileName:    fileName,
+		ContentType: detected.ContentType,
+		Hash:        hash,
+		DurationMs:  info.DurationMs,
+		Width:       info.Width,
+		Height:      info.Height,
+		Codec:       info.Codec,
+		Poster:      poster,
+		Preview:     preview,
+		Waveform:    waveform,
+	}, nil
+}
+
+// transcodedAudio is an audio upload converted to the normalized format
+type transcodedAudio struct {
+	path   string
+	format uploadFormat
+	size   int64
+	hash   string
+	info   mediaInfo
+}
+
+// transcodeAudio converts the audio file at tmpPath to
+// AUDIO_TRANSCODE_FORMAT, replacing it. If transcoding is off or fails the
+// original is kept.
+func transcodeAudio(tmpPath string) (transcodedAudio, bool) {
+	if audioTranscoder == nil || audioProcessing.Format == "" {
+		return transcodedAudio{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), audioProcessing.Timeout)
+	defer cancel()
+
+	target := transcodedAudio{format: audioTranscodeFormats[audioProcessing.Format]}
+	target.path = uploadTempPath(target.format.Ext)
+	err := audioTranscoder.Transcode(ctx, tmpPath, target.path, audioProcessing.Format)
+	if err == nil {
+		target.hash, err = hashFile(target.path)
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(target.path)
+	}
+	if err != nil {
+		os.Remove(target.path)
+		log.Printf("Audio qayta kodlanmadi: %v", err)
+		return transcodedAudio{}, false
+	}
+	defer f.Close()
+
+	if stat, err := f.Stat(); err == nil {
+		target.size = stat.Size()
+	}
+	target.info = probeMedia(f, target.size, target.format.Ext)
+	os.Remove(tmpPath)
+	return target, true
+}
+
+// audioWaveform computes the waveform of an audio file. WAV is read
+// directly, other formats need the transcoder to decode them. Returns nil
+// when that is not possible.
+func audioWaveform(path string, ext string) []float64 {
+	if ext != ".wav" {
+		if audioTranscoder == nil {
+			return nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), audioProcessing.Timeout)
+		defer cancel()
+
+		pcmPath := uploadTempPath(".wav")
+		defer os.Remove(pcmPath)
+		if err := audioTranscoder.DecodePCM(ctx, path, pcmPath); err != nil {
+			log.Printf("Audio dekodlanmadi: %v", err)
+			return nil
+		}
+		path = pcmPath
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	peaks, err := waveformPeaks(f, audioProcessing.Peaks)
+	if err != nil {
+		log.Printf("Waveform hisoblanmadi: %v", err)
+		return nil
+	}
+	return peaks
+}
+
+// waveformPeaks reads a PCM WAV file (8/16/24/32-bit integer or 32-bit
+// float) and returns the loudest sample of each of n equal slices, as a
+// fraction of full scale rounded to 3 decimals
+func waveformPeaks(r io.ReadSeeker, n int) ([]float64, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+
+	var format, channels, bits uint16
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, fmt.Errorf("no data chunk")
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch string(chunk[:4]) {
+		case "fmt ":
+			if size < 16 || size > 1<<10 {
+				return nil, fmt.Errorf("invalid fmt chunk")
+			}
+			fmtChunk := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return nil, err
+			}
+			format = binary.LittleEndian.Uint16(fmtChunk)
+			channels = binary.LittleEndian.Uint16(fmtChunk[2:])
+			bits = binary.LittleEndian.Uint16(fmtChunk[14:])
+			if format == 0xFFFE && size >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE: the sub-format GUID starts with the real tag
+				format = binary.LittleEndian.Uint16(fmtChunk[24:])
+			}
+		case "data":
+			if (format != 1 && format != 3) || (format == 3 && bits != 32) || channels == 0 || bits == 0 || bits%8 != 0 || bits > 32 {
+				return nil, fmt.Errorf("unsupported WAV encoding %d/%d-bit", format, bits)
+			}
+			// Streamed WAVs leave the data size unset
+			if size == 0 || size == 0xFFFFFFFF {
+				pos, _ := r.Seek(0, io.SeekCurrent)
+				end, err := r.Seek(0, io.SeekEnd)
+				if err != nil {
+					return nil, err
+				}
+				r.Seek(pos, io.SeekStart)
+				size = end - pos
+			}
+			return readPeaks(bufio.NewReader(io.LimitReader(r, size)), size, n, format, int(bits/8), int(channels))
+		default:
+			if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
 	}
 }
 
-func main() {
-	if err := loadDatabase(); err != nil {
-		log.Fatal("Database yuklashda xatolik:", err)
+func readPeaks(r io.Reader, size int64, n int, format uint16, width int, channels int) ([]float64, error) {
+	frameSize := width * channels
+	frames := size / int64(frameSize)
+	if frames == 0 {
+		return nil, fmt.Errorf("no audio samples")
+	}
+	n = int(min(int64(n), frames))
+
+	peaks := make([]float64, n)
+	frame := make([]byte, frameSize)
+	for i := int64(0); i < frames; i++ {
+		if _, err := io.ReadFull(r, frame); err != nil {
+			// Truncated file: keep what was read
+			break
+		}
+		bucket := int(i * int64(n) / frames)
+		for ch := 0; ch < channels; ch++ {
+			s := frame[ch*width : (ch+1)*width]
+			var v float64
+			switch {
+			case format == 3:
+				v = float64(math.Float32frombits(binary.LittleEndian.Uint32(s)))
+			case width == 1:
+				// 8-bit PCM is unsigned
+				v = (float64(s[0]) - 128) / 128
+			case width == 2:
+				v = float64(int16(binary.LittleEndian.Uint16(s))) / (1 << 15)
+			case width == 3:
+				v = float64(int32(uint32(s[0])<<8|uint32(s[1])<<16|uint32(s[2])<<24)>>8) / (1 << 23)
+			default:
+				v = float64(int32(binary.LittleEndian.Uint32(s))) / (1 << 31)
+			}
+			peaks[bucket] = math.Max(peaks[bucket], math.Min(math.Abs(v), 1))
+		}
 	}
-	log.Println("✓ Database muvaffaqiyatli yuklandi")
-
-	os.MkdirAll("uploads", os.ModePerm)
 
-	r := gin.Default()
+	for i := range peaks {
+		peaks[i] = math.Round(peaks[i]*1000) / 1000
+	}
+	return peaks, nil
+}
 
-	// CORS middleware qo'shish
-	r.Use(CORSMiddleware())
+// probeMedia reads duration, dimensions and codec from the container
+// headers of an audio or video file. ext is the detected storage extension.
+// Fields that cannot be determined are left empty.
+func probeMedia(r io.ReadSeeker, size int64, ext string) mediaInfo {
+	defer r.Seek(0, io.SeekStart)
+	r.Seek(0, io.SeekStart)
 
-	r.Static("/static", "./uploads")
+	var info mediaInfo
+	switch ext {
+	case ".wav":
+		info = probeWAV(r)
+	case ".mp3":
+		info = probeMP3(r, size)
+	case ".mp4", ".m4a", ".m4v", ".mov", ".3gp":
+		info = probeMP4(r, size)
+	case ".ogg":
+		info = probeOgg(r, size)
+	case ".webm", ".mkv":
+		info = probeEBML(r, size)
+	}
+	return info
+}
 
-	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+func durationMs(ms float64) *int {
+	if ms <= 0 || ms > float64(1<<31-1) {
+		return nil
+	}
+	v := int(ms + 0.5)
+	return &v
+}
 
-	// Category routes
-	r.POST("/categories", createCategory)
-	r.GET("/categories", getCategories)
-	r.GET("/categories/:id", getCategoryWithTasks)
-	r.PUT("/categories/:id", updateCategory)
-	r.DELETE("/categories/:id", deleteCategory)
+func probeWAV(r io.ReadSeeker) mediaInfo {
+	var info mediaInfo
 
-	// Task routes
-	r.POST("/tasks", createTask)
-	r.GET("/tasks", getTasks)
-	r.GET("/tasks/deleted", getDeletedTasks)
-	r.GET("/tasks/:id", getTask)
-	r.PUT("/tasks/:id", updateTask)
-	r.PUT("/tasks/:id/position", updateTaskPosition)
-	r.DELETE("/tasks/:id", deleteTask)
-	r.PUT("/tasks/:id/restore", restoreTask)
-	r.DELETE("/tasks/:id/permanent", permanentDeleteTask)
-	r.PUT("/tasks/:id/success", markTaskSuccess)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return info
+	}
 
-	// Task Item routes
-	r.POST("/task-items", createTaskItem)
-	r.GET("/task-items", getTaskItems)
-	r.GET("/task-items/:id", getTaskItem)
-	r.GET("/tasks/:id/items", getTaskItemsByTaskID)
-	r.PUT("/task-items/:id", updateTaskItem)
-	r.DELETE("/task-items/:id", deleteTaskItem)
+	var byteRate uint32
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return info
+		}
+		id := string(chunk[:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch id {
+		case "fmt ":
+			fmtChunk := make([]byte, 16)
+			if size < 16 {
+				return info
+			}
+			if _, err := io.ReadFull(r, fmtChunk); err != nil {
+				return info
+			}
+			switch binary.LittleEndian.Uint16(fmtChunk) {
+			case 1:
+				info.Codec = "pcm"
+			case 3:
+				info.Codec = "pcm_float"
+			case 6:
+				info.Codec = "alaw"
+			case 7:
+				info.Codec = "mulaw"
+			case 0xFFFE:
+				info.Codec = "pcm_extensible"
+			default:
+				info.Codec = fmt.Sprintf("wav_0x%04x", binary.LittleEndian.Uint16(fmtChunk))
+			}
+			byteRate = binary.LittleEndian.Uint32(fmtChunk[8:])
+			r.Seek(size-16+size%2, io.SeekCurrent)
+		case "data":
+			if byteRate > 0 {
+				info.DurationMs = durationMs(float64(size) * 1000 / float64(byteRate))
+			}
+			return info
+		default:
+			if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+				return info
+			}
+		}
+	}
+}
 
-	// File upload routes
-	r.POST("/upload/image", uploadImage)
-	r.POST("/upload/audio", uploadAudio)
-	r.POST("/upload/video", uploadVideo)
+var mp3Bitrates = map[[2]int][]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
 
-	log.Println("🚀 Server running on :1212")
-	log.Println("📚 Swagger: http://localhost:1212/swagger/index.html")
-	r.Run(":1212")
+var mp3SampleRates = map[int][]int{
+	1: {44100, 48000, 32000}, // MPEG-1
+	2: {22050, 24000, 16000}, // MPEG-2
+	3: {11025, 12000, 8000},  // MPEG-2.5
 }
 
-// Category handlers
+// probeMP3 uses the Xing/Info or VBRI frame count when present and falls
+// back to a constant bitrate estimate
+func probeMP3(r io.ReadSeeker, size int64) mediaInfo {
+	info := mediaInfo{Codec: "mp3"}
 
-// @Summary Create a new category
-// @Description Create a new category with the provided data
-// @Tags categories
-// @Accept json
-// @Produce json
-// @Param category body Category true "Category data"
-// @Success 201 {object} Category
-// @Failure 400 {object} map[string]string
-// @Router /categories [post]
-func createCategory(c *gin.Context) {
-	var category Category
-	if err := c.ShouldBindJSON(&category); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+	// Skip ID3v2 tag
+	var start int64
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return info
+	}
+	if string(header[:3]) == "ID3" {
+		start = 10 + (int64(header[6]&0x7f)<<21 | int64(header[7]&0x7f)<<14 | int64(header[8]&0x7f)<<7 | int64(header[9]&0x7f))
+		if header[5]&0x10 != 0 {
+			start += 10
+		}
 	}
 
-	dbMutex.Lock()
-	category.ID = uuid.New().String()
-	db.Categories = append(db.Categories, category)
-	saveDatabase()
-	dbMutex.Unlock()
+	// Find the first frame header within the next 64KB
+	r.Seek(start, io.SeekStart)
+	buf := make([]byte, 64*1024)
+	n, _ := io.ReadFull(r, buf)
+	buf = buf[:n]
 
-	c.JSON(201, category)
+	for i := 0; i+4 <= len(buf); i++ {
+		if buf[i] != 0xFF || buf[i+1]&0xE0 != 0xE0 {
+			continue
+		}
+
+		var version int
+		switch (buf[i+1] >> 3) & 0x03 {
+		case 3:
+			version = 1
+		case 2:
+			version = 2
+		case 0:
+			version = 3
+		default:
+			continue
+		}
+		layer := 4 - int((buf[i+1]>>1)&0x03)
+		bitrateIndex := int(buf[i+2] >> 4)
+		rateIndex := int((buf[i+2] >> 2) & 0x03)
+		if layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+			continue
+		}
+
+		table := version
+		if table == 3 {
+			table = 2
+		}
+		bitrate := mp3Bitrates[[2]int{table, layer}][bitrateIndex] * 1000
+		sampleRate := mp3SampleRates[version][rateIndex]
+		mono := buf[i+3]>>6 == 3
+
+		samplesPerFrame := 1152
+		if layer == 1 {
+			samplesPerFrame = 384
+		} else if layer == 3 && version != 1 {
+			samplesPerFrame = 576
+		}
+
+		// Xing/Info header sits after the side information
+		sideInfo := 32
+		if version == 1 && mono {
+			sideInfo = 17
+		} else if version != 1 && !mono {
+			sideInfo = 17
+		} else if version != 1 && mono {
+			sideInfo = 9
+		}
+		if x := i + 4 + sideInfo; x+12 <= len(buf) {
+			tag := string(buf[x : x+4])
+			if (tag == "Xing" || tag == "Info") && buf[x+7]&0x01 != 0 {
+				frames := binary.BigEndian.Uint32(buf[x+8:])
+				info.DurationMs = durationMs(float64(frames) * float64(samplesPerFrame) * 1000 / float64(sampleRate))
+				return info
+			}
+		}
+		if v := i + 36; v+18 <= len(buf) && string(buf[v:v+4]) == "VBRI" {
+			frames := binary.BigEndian.Uint32(buf[v+14:])
+			info.DurationMs = durationMs(float64(frames) * float64(samplesPerFrame) * 1000 / float64(sampleRate))
+			return info
+		}
+
+		audioBytes := size - start - int64(i)
+		tail := make([]byte, 3)
+		if _, err := r.Seek(size-128, io.SeekStart); err == nil {
+			if _, err := io.ReadFull(r, tail); err == nil && string(tail) == "TAG" {
+				audioBytes -= 128
+			}
+		}
+		info.DurationMs = durationMs(float64(audioBytes) * 8 * 1000 / float64(bitrate))
+		return info
+	}
+
+	return info
 }
 
-// @Summary Get all categories
-// @Description Get a list of all categories
-// @Tags categories
-// @Produce json
-// @Success 200 {array} Category
-// @Router /categories [get]
-func getCategories(c *gin.Context) {
-	dbMutex.RLock()
-	defer dbMutex.RUnlock()
-	c.JSON(200, db.Categories)
+// mp4Boxes iterates the child boxes in data, calling fn with each type and payload
+func mp4Boxes(data []byte, fn func(boxType string, payload []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		headerSize := uint64(8)
+		if size == 1 && len(data) >= 16 {
+			size = binary.BigEndian.Uint64(data[8:])
+			headerSize = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return
+		}
+		fn(boxType, data[headerSize:size])
+		data = data[size:]
+	}
 }
 
-// @Summary Get category with tasks
-// @Description Get a single category by its ID with all its tasks
-// @Tags categories
-// @Produce json
-// @Param id path string true "Category ID"
-// @Success 200 {object} CategoryWithTasksResponse
-// @Failure 404 {object} map[string]string
-// @Router /categories/{id} [get]
-func getCategoryWithTasks(c *gin.Context) {
-	id := c.Param("id")
-	dbMutex.RLock()
-	defer dbMutex.RUnlock()
+// probeMP4 reads the moov box of an ISO-BMFF file (MP4, M4A, MOV, 3GP)
+func probeMP4(r io.ReadSeeker, size int64) mediaInfo {
+	var info mediaInfo
+
+	// Locate moov among the top-level boxes; it may be after mdat
+	var moov []byte
+	header := make([]byte, 16)
+	for pos := int64(0); pos+8 <= size; {
+		r.Seek(pos, io.SeekStart)
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return info
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		if boxSize == 1 {
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return info
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		} else if boxSize == 0 {
+			boxSize = size - pos
+		}
+		if boxSize < headerSize || boxSize > size-pos {
+			return info
+		}
+		if string(header[4:8]) == "moov" {
+			if boxSize > 64<<20 {
+				return info
+			}
+			moov = make([]byte, boxSize-headerSize)
+			if _, err := io.ReadFull(r, moov); err != nil {
+				return info
+			}
+			break
+		}
+		pos += boxSize
+	}
+	if moov == nil {
+		return info
+	}
 
-	cat := findCategoryByID(id)
-	if cat == nil {
-		c.JSON(404, gin.H{"error": "Category not found"})
-		return
+	var audioCodec string
+	mp4Boxes(moov, func(boxType string, payload []byte) {
+		switch boxType {
+		case "mvhd":
+			if len(payload) >= 32 && payload[0] == 1 {
+				timescale := binary.BigEndian.Uint32(payload[20:])
+				duration := binary.BigEndian.Uint64(payload[24:])
+				if timescale > 0 {
+					info.DurationMs = durationMs(float64(duration) * 1000 / float64(timescale))
+				}
+			} else if len(payload) >= 20 {
+				timescale := binary.BigEndian.Uint32(payload[12:])
+				duration := binary.BigEndian.Uint32(payload[16:])
+				if timescale > 0 {
+					info.DurationMs = durationMs(float64(duration) * 1000 / float64(timescale))
+				}
+			}
+		case "trak":
+			var handler, codec string
+			var width, height int
+			var rotated bool
+			mp4Boxes(payload, func(boxType string, payload []byte) {
+				switch boxType {
+				case "tkhd":
+					offset := 76
+					if len(payload) > 0 && payload[0] == 1 {
+						offset = 88
+					}
+					if len(payload) >= offset+8 {
+						width = int(binary.BigEndian.Uint32(payload[offset:]) >> 16)
+						height = int(binary.BigEndian.Uint32(payload[offset+4:]) >> 16)
+						// A 90/270 degree matrix (a == 0, d == 0) means the
+						// frames are displayed sideways
+						matrix := payload[offset-36:]
+						rotated = binary.BigEndian.Uint32(matrix) == 0 && binary.BigEndian.Uint32(matrix[16:]) == 0
+					}
+				case "mdia":
+					mp4Boxes(payload, func(boxType string, payload []byte) {
+						switch boxType {
+						case "hdlr":
+							if len(payload) >= 12 {
+								handler = string(payload[8:12])
+							}
+						case "minf":
+							mp4Boxes(payload, func(boxType string, payload []byte) {
+								if boxType != "stbl" {
+									return
+								}
+								mp4Boxes(payload, func(boxType string, payload []byte) {
+									if boxType == "stsd" && len(payload) >= 16 {
+										codec = strings.TrimSpace(string(payload[12:16]))
+									}
+								})
+							})
+						}
+					})
+				}
+			})
+			switch handler {
+			case "vide":
+				if info.Codec == "" {
+					info.Codec = codec
+					info.Width, info.Height = width, height
+					if rotated {
+						info.Width, info.Height = height, width
+					}
+				}
+			case "soun":
+				if audioCodec == "" {
+					audioCodec = codec
+				}
+			}
+		}
+	})
+
+	if info.Codec == "" {
+		info.Codec = audioCodec
 	}
+	return info
+}
 
-	// Get all tasks for this category
-	tasks := getTasksByCategoryID(id, false)
+// probeOgg reads the codec from the first page and the duration from the
+// granule position of the last page
+func probeOgg(r io.ReadSeeker, size int64) mediaInfo {
+	var info mediaInfo
 
-	// Convert tasks to response format
-	var taskResponses []TaskResponse
-	for _, task := range tasks {
-		taskResponses = append(taskResponses, convertToTaskResponse(task))
+	first := make([]byte, 4096)
+	n, _ := io.ReadFull(r, first)
+	first = first[:n]
+	if len(first) < 28 || string(first[:4]) != "OggS" {
+		return info
 	}
 
-	response := CategoryWithTasksResponse{
-		ID:    cat.ID,
-		Data:  cat.Data,
-		Tasks: taskResponses,
+	// The first packet ends at the first lacing value below 255
+	segments := int(first[26])
+	if len(first) < 27+segments {
+		return info
+	}
+	packetSize := 0
+	for _, lacing := range first[27 : 27+segments] {
+		packetSize += int(lacing)
+		if lacing < 255 {
+			break
+		}
+	}
+	if len(first) < 27+segments+packetSize {
+		return info
+	}
+	packet := first[27+segments : 27+segments+packetSize]
+
+	var sampleRate float64
+	var preSkip uint64
+	switch {
+	case len(packet) >= 16 && string(packet[1:7]) == "vorbis":
+		info.Codec = "vorbis"
+		sampleRate = float64(binary.LittleEndian.Uint32(packet[12:]))
+	case len(packet) >= 16 && string(packet[:8]) == "OpusHead":
+		info.Codec = "opus"
+		sampleRate = 48000 // Opus granule positions are always 48kHz
+		preSkip = uint64(binary.LittleEndian.Uint16(packet[10:]))
+	case len(packet) >= 5 && string(packet[1:5]) == "FLAC":
+		info.Codec = "flac"
+		if len(packet) >= 30 {
+			streamInfo := packet[17:]
+			sampleRate = float64(uint32(streamInfo[10])<<12 | uint32(streamInfo[11])<<4 | uint32(streamInfo[12])>>4)
+		}
+	default:
+		return info
+	}
+	if sampleRate == 0 {
+		return info
 	}
 
-	c.JSON(200, response)
+	tailSize := int64(64 * 1024)
+	if tailSize > size {
+		tailSize = size
+	}
+	r.Seek(size-tailSize, io.SeekStart)
+	tail := make([]byte, tailSize)
+	n, _ = io.ReadFull(r, tail)
+	tail = tail[:n]
+
+	last := bytes.LastIndex(tail, []byte("OggS"))
+	if last < 0 || last+14 > len(tail) {
+		return info
+	}
+	granule := binary.LittleEndian.Uint64(tail[last+6:])
+	if granule > preSkip && granule != ^uint64(0) {
+		info.DurationMs = durationMs(float64(granule-preSkip) * 1000 / sampleRate)
+	}
+	return info
 }
 
-// @Summary Update category
-// @Description Update an existing category by ID
-// @Tags categories
-// @Accept json
-// @Produce json
-// @Param id path string true "Category ID"
-// @Param category body Category true "Updated category data"
-// @Success 200 {object} Category
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /categories/{id} [put]
-func updateCategory(c *gin.Context) {
-	id := c.Param("id")
+// EBML element IDs used by probeEBML (WebM/Matroska)
+const (
+	ebmlSegment        = 0x18538067
+	ebmlInfo           = 0x1549A966
+	ebmlTimecodeScale  = 0x2AD7B1
+	ebmlDuration       = 0x4489
+	ebmlTracks         = 0x1654AE6B
+	ebmlTrackEntry     = 0xAE
+	ebmlTrackType      = 0x83
+	ebmlCodecID        = 0x86
+	ebmlVideo          = 0xE0
+	ebmlPixelWidth     = 0xB0
+	ebmlPixelHeight    = 0xBA
+	ebmlCluster        = 0x1F43B675
+	ebmlClusterTime    = 0xE7
+	ebmlBlockGroup     = 0xA0
+	ebmlBlock          = 0xA1
+	ebmlSimpleBlock    = 0xA3
+	ebmlUnknownSize    = -1
+	ebmlMaxElementSize = 16 << 20
+)
 
-	var input Category
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+// readEBMLVint reads an EBML variable length integer. For IDs the length
+// marker is kept; for sizes it is stripped and all-ones means unknown size.
+func readEBMLVint(r io.Reader, keepMarker bool) (int64, int, error) {
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(r, first); err != nil {
+		return 0, 0, err
+	}
+	length := 1
+	for mask := byte(0x80); length <= 8 && first[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, fmt.Errorf("invalid EBML vint")
 	}
 
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+	value := int64(first[0])
+	if !keepMarker {
+		value &= int64(0xFF >> length)
+	}
+	allOnes := value == int64(0xFF>>length)
 
-	cat := findCategoryByID(id)
-	if cat == nil {
-		c.JSON(404, gin.H{"error": "Category not found"})
-		return
+	rest := make([]byte, length-1)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return 0, 0, err
+	}
+	for _, b := range rest {
+		value = value<<8 | int64(b)
+		allOnes = allOnes && b == 0xFF
 	}
+	if !keepMarker && allOnes {
+		return ebmlUnknownSize, length, nil
+	}
+	return value, length, nil
+}
 
-	cat.Data = input.Data
-	saveDatabase()
+func ebmlUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
 
-	c.JSON(200, cat)
+// ebmlChildren iterates the elements of an in-memory master element
+func ebmlChildren(data []byte, fn func(id int64, payload []byte)) {
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		id, _, err := readEBMLVint(r, true)
+		if err != nil {
+			return
+		}
+		size, _, err := readEBMLVint(r, false)
+		if err != nil || size < 0 || size > int64(r.Len()) {
+			return
+		}
+		payload := data[len(data)-r.Len() : len(data)-r.Len()+int(size)]
+		fn(id, payload)
+		r.Seek(size, io.SeekCurrent)
+	}
 }
 
-// @Summary Delete category
-// @Description Delete a category by ID
-// @Tags categories
-// @Produce json
-// @Param id path string true "Category ID"
-// @Success 200 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /categories/{id} [delete]
-func deleteCategory(c *gin.Context) {
-	id := c.Param("id")
+// probeEBML reads Info and Tracks of a WebM/Matroska file. Recordings from
+// browsers often have no Duration, so clusters are scanned for the last
+// block timestamp instead.
+func probeEBML(r io.ReadSeeker, size int64) mediaInfo {
+	var info mediaInfo
+	timecodeScale := uint64(1000000)
+	var duration float64
+	var haveInfo, haveTracks bool
+	var clusterTime, lastTime int64
+	var audioCodec string
+
+	pos := int64(0)
+	for pos < size {
+		r.Seek(pos, io.SeekStart)
+		id, idLen, err := readEBMLVint(r, true)
+		if err != nil {
+			break
+		}
+		elemSize, sizeLen, err := readEBMLVint(r, false)
+		if err != nil {
+			break
+		}
+		payloadStart := pos + int64(idLen+sizeLen)
 
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+		switch id {
+		case ebmlSegment, ebmlCluster, ebmlBlockGroup:
+			// Descend: continue with the first child
+			if id == ebmlCluster && haveInfo && haveTracks && duration > 0 {
+				pos = size
+				continue
+			}
+			pos = payloadStart
+			continue
+		case ebmlInfo, ebmlTracks:
+			if elemSize < 0 || elemSize > ebmlMaxElementSize {
+				return info
+			}
+			payload := make([]byte, elemSize)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return info
+			}
+			if id == ebmlInfo {
+				haveInfo = true
+				ebmlChildren(payload, func(id int64, payload []byte) {
+					switch id {
+					case ebmlTimecodeScale:
+						timecodeScale = ebmlUint(payload)
+					case ebmlDuration:
+						if len(payload) == 4 {
+							duration = float64(math.Float32frombits(binary.BigEndian.Uint32(payload)))
+						} else if len(payload) == 8 {
+							duration = math.Float64frombits(binary.BigEndian.Uint64(payload))
+						}
+					}
+				})
+			} else {
+				haveTracks = true
+				ebmlChildren(payload, func(id int64, payload []byte) {
+					if id != ebmlTrackEntry {
+						return
+					}
+					var trackType uint64
+					var codec string
+					var width, height int
+					ebmlChildren(payload, func(id int64, payload []byte) {
+						switch id {
+						case ebmlTrackType:
+							trackType = ebmlUint(payload)
+						case ebmlCodecID:
+							codec = string(payload)
+						case ebmlVideo:
+							ebmlChildren(payload, func(id int64, payload []byte) {
+								switch id {
+								case ebmlPixelWidth:
+									width = int(ebmlUint(payload))
+								case ebmlPixelHeight:
+									height = int(ebmlUint(payload))
+								}
+							})
+						}
+					})
+					codec = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(codec, "V_"), "A_"))
+					if trackType == 1 && info.Codec == "" {
+						info.Codec = codec
+						info.Width, info.Height = width, height
+					} else if trackType == 2 && audioCodec == "" {
+						audioCodec = codec
+					}
+				})
+			}
+		case ebmlClusterTime:
+			if elemSize > 0 && elemSize <= 8 {
+				payload := make([]byte, elemSize)
+				if _, err := io.ReadFull(r, payload); err == nil {
+					clusterTime = int64(ebmlUint(payload))
+				}
+			}
+		case ebmlSimpleBlock, ebmlBlock:
+			// Track number vint, then a signed 16-bit relative timestamp
+			if _, _, err := readEBMLVint(r, false); err == nil {
+				rel := make([]byte, 2)
+				if _, err := io.ReadFull(r, rel); err == nil {
+					if t := clusterTime + int64(int16(binary.BigEndian.Uint16(rel))); t > lastTime {
+						lastTime = t
+					}
+				}
+			}
+		}
 
-	if !deleteCategoryByID(id) {
-		c.JSON(404, gin.H{"error": "Category not found"})
-		return
+		if elemSize < 0 {
+			break
+		}
+		pos = payloadStart + elemSize
 	}
 
-	saveDatabase()
-	c.JSON(200, gin.H{"message": "Category deleted"})
-}
-
-// Task handlers
+	if info.Codec == "" {
+		info.Codec = audioCodec
+	}
+	if duration <= 0 {
+		duration = float64(lastTime)
+	}
+	info.DurationMs = durationMs(duration * float64(timecodeScale) / 1e6)
+	return info
+}
 
-// @Summary Create a new task
-// @Description Create a new task with category, name, and optional position
-// @Tags tasks
-// @Accept json
+// @Summary Upload image
+// @Description Upload an image file (JPEG, PNG, GIF, BMP, TIFF, WebP, HEIC/HEIF). The type is detected from the file content. The stored format follows IMAGE_STORAGE_FORMAT (original, jpeg, webp-lossless, webp-lossy); HEIC/HEIF is stored as JPEG when keeping the original. Metadata is always removed. Identical content is stored once: the existing upload is returned with duplicate set.
+// @Tags uploads
+// @Accept multipart/form-data
 // @Produce json
-// @Param task body object{category_id=string,name=string,is_success=bool,price=number,position=int} true "Task data"
-// @Success 201 {object} TaskResponse
-// @Failure 400 {object} map[string]string
-// @Router /tasks [post]
-func createTask(c *gin.Context) {
-	var input struct {
-		CategoryID string   `json:"category_id"`
-		Name       string   `json:"name"`
-		IsSuccess  bool     `json:"is_success"`
-		Price      *float32 `json:"price"`
-		Position   *int     `json:"position"`
-	}
+// @Param X-User-ID header string false "Uploader, charged against the user quota"
+// @Param category_id formData string false "Category charged against the category quota"
+// @Param task_id formData string false "Task the image is for; similar images already on it are returned with a warning"
+// @Param image formData file true "Image file"
+// @Success 200 {object} UploadResponse
+// @Failure 400 {object} UploadResponse
+// @Failure 413 {object} UploadResponse
+// @Failure 415 {object} UploadResponse
+// @Failure 500 {object} UploadResponse
+// @Router /upload/image [post]
+func uploadImage(c *gin.Context) {
+	limitUploadBody(c, uploadLimits.Image)
 
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	file, handler, err := c.Request.FormFile("image")
+	if err != nil {
+		formFileFailure(err, "Rasmni olishda xatolik: ").respond(c)
 		return
 	}
+	defer file.Close()
 
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
-
-	task := Task{
-		ID:         uuid.New().String(),
-		CategoryID: input.CategoryID,
-		Name:       input.Name,
-		IsSuccess:  input.IsSuccess,
-		Price:      input.Price,
+	ownerID, categoryID := uploadOwner(c)
+	if failure := preCheckQuota(ownerID, categoryID); failure != nil {
+		failure.respond(c)
+		return
 	}
 
-	if input.Position == nil {
-		maxPos := -1
-		for _, t := range db.Tasks {
-			if t.DeletedAt == nil && t.Position > maxPos {
-				maxPos = t.Position
-			}
-		}
-		task.Position = maxPos + 1
-	} else {
-		task.Position = *input.Position
-		for i := range db.Tasks {
-			if db.Tasks[i].DeletedAt == nil && db.Tasks[i].Position >= *input.Position {
-				db.Tasks[i].Position++
-			}
-		}
+	data, failure := processImageUpload(file, handler.Filename)
+	if failure != nil {
+		failure.respond(c)
+		return
 	}
 
-	db.Tasks = append(db.Tasks, task)
-	saveDatabase()
+	if failure := recordUpload(&data, ownerID, categoryID); failure != nil {
+		failure.respond(c)
+		return
+	}
 
-	response := convertToTaskResponse(task)
-	c.JSON(201, response)
+	warning := nearDuplicateWarning(&data, c.DefaultPostForm("task_id", c.Query("task_id")))
+	signUploadData(&data)
+	c.JSON(200, UploadResponse{
+		Success:    true,
+		StatusCode: 200,
+		Message:    "Rasm muvaffaqiyatli yuklandi",
+		Warning:    warning,
+		Data:       data,
+	})
 }
 
-// @Summary Get all tasks
-// @Description Get a list of all active tasks ordered by position
-// @Tags tasks
+// @Summary Upload audio
+// @Description Upload an audio file (MP3, WAV, OGG, M4A, AAC, FLAC, AMR, WebM, WMA). The type is detected from the file content and must match the file extension. Duration and codec are read from MP3, WAV, OGG, M4A and WebM headers. A waveform (peak per slice, 0-1) is computed for WAV, and for other formats when ffmpeg is available; with AUDIO_TRANSCODE_FORMAT set the file is stored loudness-normalized in that format. Identical content is stored once: the existing upload is returned with duplicate set.
+// @Tags uploads
+// @Accept multipart/form-data
 // @Produce json
-// @Success 200 {array} TaskResponse
-// @Router /tasks [get]
-func getTasks(c *gin.Context) {
-	dbMutex.RLock()
-	defer dbMutex.RUnlock()
+// @Param X-User-ID header string false "Uploader, charged against the user quota"
+// @Param category_id formData string false "Category charged against the category quota"
+// @Param audio formData file true "Audio file"
+// @Success 200 {object} UploadResponse
+// @Failure 400 {object} UploadResponse
+// @Failure 413 {object} UploadResponse
+// @Failure 415 {object} UploadResponse
+// @Failure 500 {object} UploadResponse
+// @Router /upload/audio [post]
+func uploadAudio(c *gin.Context) {
+	limitUploadBody(c, uploadLimits.Audio)
 
-	var responses []TaskResponse
-	var activeTasks []Task
+	file, handler, err := c.Request.FormFile("audio")
+	if err != nil {
+		formFileFailure(err, "Audioni olishda xatolik: ").respond(c)
+		return
+	}
+	defer file.Close()
 
-	for _, task := range db.Tasks {
-		if task.DeletedAt == nil {
-			activeTasks = append(activeTasks, task)
-		}
+	ownerID, categoryID := uploadOwner(c)
+	if failure := preCheckQuota(ownerID, categoryID); failure != nil {
+		failure.respond(c)
+		return
 	}
 
-	// Sort by position
-	sort.Slice(activeTasks, func(i, j int) bool {
-		return activeTasks[i].Position < activeTasks[j].Position
-	})
+	data, failure := processMediaUpload(file, handler.Filename, audioUploadPolicy)
+	if failure != nil {
+		failure.respond(c)
+		return
+	}
 
-	for _, task := range activeTasks {
-		responses = append(responses, convertToTaskResponse(task))
+	if failure := recordUpload(&data, ownerID, categoryID); failure != nil {
+		failure.respond(c)
+		return
 	}
 
-	c.JSON(200, responses)
+	signUploadData(&data)
+	c.JSON(200, UploadResponse{
+		Success:    true,
+		StatusCode: 200,
+		Message:    "Audio muvaffaqiyatli yuklandi",
+		Data:       data,
+	})
 }
 
-// @Summary Get deleted tasks
-// @Description Get a list of all soft-deleted tasks
-// @Tags tasks
+// @Summary Upload video
+// @Description Upload a video file (MP4, MOV, M4V, 3GP, WebM, MKV, AVI, FLV, WMV). The type is detected from the file content and must match the file extension. Duration, dimensions and codec are read from MP4/MOV and WebM/MKV headers. When ffmpeg is available a poster frame, and with VIDEO_PREVIEW=true a short animated GIF preview, are stored with the upload.
+// @Tags uploads
+// @Accept multipart/form-data
 // @Produce json
-// @Success 200 {array} TaskResponse
-// @Router /tasks/deleted [get]
-func getDeletedTasks(c *gin.Context) {
-	dbMutex.RLock()
-	defer dbMutex.RUnlock()
+// @Param X-User-ID header string false "Uploader, charged against the user quota"
+// @Param category_id formData string false "Category charged against the category quota"
+// @Param video formData file true "Video file"
+// @Success 200 {object} UploadResponse
+// @Failure 400 {object} UploadResponse
+// @Failure 413 {object} UploadResponse
+// @Failure 415 {object} UploadResponse
+// @Failure 500 {object} UploadResponse
+// @Router /upload/video [post]
+func uploadVideo(c *gin.Context) {
+	limitUploadBody(c, uploadLimits.Video)
 
-	var responses []TaskResponse
-	for _, task := range db.Tasks {
-		if task.DeletedAt != nil {
-			responses = append(responses, convertToTaskResponse(task))
-		}
+	file, handler, err := c.Request.FormFile("video")
+	if err != nil {
+		formFileFailure(err, "Videoni olishda xatolik: ").respond(c)
+		return
 	}
+	defer file.Close()
 
-	c.JSON(200, responses)
-}
-
-// @Summary Get task by ID
-// @Description Get a single task by its ID
-// @Tags tasks
-// @Produce json
-// @Param id path string true "Task ID"
-// @Success 200 {object} TaskResponse
-// @Failure 404 {object} map[string]string
-// @Router /tasks/{id} [get]
-func getTask(c *gin.Context) {
-	id := c.Param("id")
+	ownerID, categoryID := uploadOwner(c)
+	if failure := preCheckQuota(ownerID, categoryID); failure != nil {
+		failure.respond(c)
+		return
+	}
 
-	dbMutex.RLock()
-	defer dbMutex.RUnlock()
+	data, failure := processMediaUpload(file, handler.Filename, videoUploadPolicy)
+	if failure != nil {
+		failure.respond(c)
+		return
+	}
 
-	task := findTaskByID(id, false)
-	if task == nil {
-		c.JSON(404, gin.H{"error": "Task not found"})
+	if failure := recordUpload(&data, ownerID, categoryID); failure != nil {
+		failure.respond(c)
 		return
 	}
 
-	response := convertToTaskResponse(*task)
-	c.JSON(200, response)
+	signUploadData(&data)
+	c.JSON(200, UploadResponse{
+		Success:    true,
+		StatusCode: 200,
+		Message:    "Video muvaffaqiyatli yuklandi",
+		Data:       data,
+	})
 }
 
-// @Summary Update task
-// @Description Update an existing task
-// @Tags tasks
-// @Accept json
+// @Summary Upload many files
+// @Description Upload up to UPLOAD_BATCH_MAX_FILES files of mixed kinds at once (repeat the files field). The kind of each file is detected from its content and it goes through the same pipeline as /upload/image, /upload/audio or /upload/video, UPLOAD_BATCH_WORKERS files at a time. Every file gets its own result, in the order sent; one failing file does not affect the others.
+// @Tags uploads
+// @Accept multipart/form-data
 // @Produce json
-// @Param id path string true "Task ID"
-// @Param task body object{category_id=string,name=string,is_success=bool,price=number} true "Updated task data"
-// @Success 200 {object} TaskResponse
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /tasks/{id} [put]
-func updateTask(c *gin.Context) {
-	id := c.Param("id")
+// @Param X-User-ID header string false "Uploader, charged against the user quota"
+// @Param category_id formData string false "Category charged against the category quota"
+// @Param task_id formData string false "Task the files are for; images get a warning when a similar one is already on it"
+// @Param files formData file true "Files"
+// @Success 200 {object} BatchUploadResponse
+// @Failure 400 {object} UploadResponse
+// @Failure 413 {object} UploadResponse
+// @Router /upload/batch [post]
+func uploadBatch(c *gin.Context) {
+	limitUploadBody(c, maxUploadBody())
 
-	var input struct {
-		CategoryID string   `json:"category_id"`
-		Name       string   `json:"name"`
-		IsSuccess  bool     `json:"is_success"`
-		Price      *float32 `json:"price"`
+	form, err := c.MultipartForm()
+	if err != nil {
+		formFileFailure(err, "Fayllarni olishda xatolik: ").respond(c)
+		return
 	}
-
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	headers := form.File["files"]
+	if len(headers) == 0 {
+		(&uploadFailure{400, errCodeMissingFile, "Fayllarni olishda xatolik: files maydoni bo'sh"}).respond(c)
+		return
+	}
+	if len(headers) > uploadBatchLimits.MaxFiles {
+		(&uploadFailure{400, errCodeTooMany, fmt.Sprintf("Bir so'rovda ko'pi bilan %d ta fayl yuklash mumkin", uploadBatchLimits.MaxFiles)}).respond(c)
 		return
 	}
 
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
-
-	task := findTaskByID(id, false)
-	if task == nil {
-		c.JSON(404, gin.H{"error": "Task not found"})
+	ownerID, categoryID := uploadOwner(c)
+	if failure := preCheckQuota(ownerID, categoryID); failure != nil {
+		failure.respond(c)
 		return
 	}
+	taskID := c.DefaultPostForm("task_id", c.Query("task_id"))
+
+	results := make([]BatchUploadResult, len(headers))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(uploadBatchLimits.Workers, len(headers)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = uploadBatchFile(headers[i], ownerID, categoryID, taskID)
+			}
+		}()
+	}
+	for i := range headers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
 
-	task.CategoryID = input.CategoryID
-	task.Name = input.Name
-	task.IsSuccess = input.IsSuccess
-	task.Price = input.Price
+	response := BatchUploadResponse{StatusCode: 200, Results: results}
+	for _, result := range results {
+		if result.Success {
+			response.Uploaded++
+		} else {
+			response.Failed++
+		}
+	}
+	response.Success = response.Failed == 0
+	response.Message = fmt.Sprintf("%d / %d fayl yuklandi", response.Uploaded, len(results))
+	c.JSON(200, response)
+}
 
-	saveDatabase()
+// uploadBatchFile stores one file of a batch upload. It runs on a worker
+// goroutine, where a panic would take the whole server down (gin only
+// recovers the handler goroutine), so a panic fails just this file.
+func uploadBatchFile(header *multipart.FileHeader, ownerID string, categoryID string, taskID string) (result BatchUploadResult) {
+	result = BatchUploadResult{FileName: header.Filename}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Batch yuklash: %s faylida panic: %v", header.Filename, r)
+			result = BatchUploadResult{
+				FileName:   header.Filename,
+				Kind:       result.Kind,
+				StatusCode: 500,
+				ErrorCode:  errCodeInternal,
+				Message:    "Faylni qayta ishlashda kutilmagan xatolik",
+			}
+		}
+	}()
 
-	response := convertToTaskResponse(*task)
-	c.JSON(200, response)
+	data, kind, failure := processUploadFile(header)
+	result.Kind = kind
+	if failure == nil {
+		failure = recordUpload(&data, ownerID, categoryID)
+	}
+	if failure != nil {
+		result.StatusCode = failure.Status
+		result.ErrorCode = failure.Code
+		result.Message = failure.Message
+		return result
+	}
+
+	result.Warning = nearDuplicateWarning(&data, taskID)
+	signUploadData(&data)
+	result.Success = true
+	result.StatusCode = 200
+	result.Message = "Fayl muvaffaqiyatli yuklandi"
+	result.Data = &data
+	return result
 }
 
-// @Summary Update task position
-// @Description Change the position of a task in the list
-// @Tags tasks
-// @Accept json
+// @Summary Get upload storage usage
+// @Description Get bytes and files consumed by uploads, per user and per category, with the configured quotas
+// @Tags uploads
 // @Produce json
-// @Param id path string true "Task ID"
-// @Param position body object{position=int} true "New position"
-// @Success 200 {object} TaskResponse
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /tasks/{id}/position [put]
-func updateTaskPosition(c *gin.Context) {
-	id := c.Param("id")
+// @Param user_id query string false "Only this user"
+// @Param category_id query string false "Only this category"
+// @Success 200 {object} UsageReport
+// @Router /uploads/usage [get]
+func getUploadUsage(c *gin.Context) {
+	userID := c.Query("user_id")
+	categoryID := c.Query("category_id")
 
-	var input struct {
-		Position int `json:"position"`
-	}
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
 
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+	report := UsageReport{
+		Users:      []UsageEntry{},
+		Categories: []UsageEntry{},
 	}
+	report.TotalBytes, report.TotalFiles = uploadUsage(userID, categoryID)
 
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+	users := map[string]bool{}
+	categories := map[string]bool{}
+	for _, u := range db.Uploads {
+		if u.OwnerID != "" && (userID == "" || u.OwnerID == userID) {
+			users[u.OwnerID] = true
+		}
+		if u.CategoryID != "" && (categoryID == "" || u.CategoryID == categoryID) {
+			categories[u.CategoryID] = true
+		}
+	}
 
-	task := findTaskByID(id, false)
-	if task == nil {
-		c.JSON(404, gin.H{"error": "Task not found"})
-		return
+	for id := range users {
+		bytes, files := uploadUsage(id, "")
+		report.Users = append(report.Users, UsageEntry{ID: id, Bytes: bytes, Files: files, QuotaBytes: uploadLimits.UserQuota})
+	}
+	for id := range categories {
+		bytes, files := uploadUsage("", id)
+		report.Categories = append(report.Categories, UsageEntry{ID: id, Bytes: bytes, Files: files, QuotaBytes: uploadLimits.CategoryQuota})
 	}
 
-	oldPos := task.Position
-	newPos := input.Position
+	sort.Slice(report.Users, func(i, j int) bool { return report.Users[i].ID < report.Users[j].ID })
+	sort.Slice(report.Categories, func(i, j int) bool { return report.Categories[i].ID < report.Categories[j].ID })
 
-	if oldPos != newPos {
-		if oldPos < newPos {
-			for i := range db.Tasks {
-				if db.Tasks[i].DeletedAt == nil && db.Tasks[i].Position > oldPos && db.Tasks[i].Position <= newPos {
-					db.Tasks[i].Position--
-				}
-			}
-		} else {
-			for i := range db.Tasks {
-				if db.Tasks[i].DeletedAt == nil && db.Tasks[i].Position >= newPos && db.Tasks[i].Position < oldPos {
-					db.Tasks[i].Position++
-				}
-			}
+	c.JSON(200, report)
+}
+
+// @Summary Get uploads
+// @Description Get registered uploads, newest first
+// @Tags uploads
+// @Produce json
+// @Param user_id query string false "Only uploads by this user"
+// @Param category_id query string false "Only uploads charged to this category"
+// @Success 200 {array} Upload
+// @Router /uploads [get]
+func getUploads(c *gin.Context) {
+	userID := c.Query("user_id")
+	categoryID := c.Query("category_id")
+
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	uploads := []Upload{}
+	for _, u := range db.Uploads {
+		if (userID == "" || u.OwnerID == userID) && (categoryID == "" || u.CategoryID == categoryID) {
+			uploads = append(uploads, u)
 		}
-		task.Position = newPos
 	}
+	sort.SliceStable(uploads, func(i, j int) bool { return uploads[i].CreatedAt.After(uploads[j].CreatedAt) })
 
-	saveDatabase()
+	for i := range uploads {
+		uploads[i] = *signedUpload(&uploads[i])
+	}
 
-	response := convertToTaskResponse(*task)
-	c.JSON(200, response)
+	c.JSON(200, uploads)
 }
 
-// @Summary Soft delete task
-// @Description Soft delete a task (can be restored later)
-// @Tags tasks
+// @Summary Get upload by ID
+// @Description Get a registered upload and its metadata
+// @Tags uploads
 // @Produce json
-// @Param id path string true "Task ID"
-// @Success 200 {object} map[string]string
+// @Param id path string true "Upload ID"
+// @Success 200 {object} Upload
 // @Failure 404 {object} map[string]string
-// @Router /tasks/{id} [delete]
-func deleteTask(c *gin.Context) {
+// @Router /uploads/{id} [get]
+func getUpload(c *gin.Context) {
 	id := c.Param("id")
 
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
 
-	task := findTaskByID(id, false)
-	if task == nil {
-		c.JSON(404, gin.H{"error": "Task not found"})
+	upload := findUploadByID(id)
+	if upload == nil {
+		c.JSON(404, gin.H{"error": "Upload not found"})
 		return
 	}
 
-	now := time.Now()
-	task.DeletedAt = &now
-
-	for i := range db.Tasks {
-		if db.Tasks[i].DeletedAt == nil && db.Tasks[i].Position > task.Position {
-			db.Tasks[i].Position--
-		}
-	}
-
-	saveDatabase()
-	c.JSON(200, gin.H{"message": "Task deleted (soft delete)"})
+	c.JSON(200, signedUpload(upload))
 }
 
-// @Summary Restore deleted task
-// @Description Restore a soft-deleted task
-// @Tags tasks
+// @Summary Find similar images
+// @Description Find image uploads that look like this one (perceptual hash distance up to max_distance bits of 64), among the items of a task or of a category's tasks, closest first. Without task_id and category_id all task items are searched.
+// @Tags uploads
 // @Produce json
-// @Param id path string true "Task ID"
-// @Success 200 {object} map[string]interface{}
+// @Param id path string true "Upload ID"
+// @Param task_id query string false "Search this task"
+// @Param category_id query string false "Search the tasks of this category"
+// @Param max_distance query int false "Largest distance, 0-64 (default IMAGE_SIMILAR_DISTANCE)"
+// @Success 200 {array} SimilarImage
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
-// @Router /tasks/{id}/restore [put]
-func restoreTask(c *gin.Context) {
+// @Router /uploads/{id}/similar [get]
+func getSimilarUploads(c *gin.Context) {
 	id := c.Param("id")
 
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+	maxDistance := similarImageDistance
+	if raw := c.Query("max_distance"); raw != "" {
+		if _, err := fmt.Sscanf(raw, "%d", &maxDistance); err != nil || maxDistance < 0 || maxDistance > 64 {
+			c.JSON(400, gin.H{"error": "max_distance must be between 0 and 64"})
+			return
+		}
+	}
 
-	task := findTaskByID(id, true)
-	if task == nil {
-		c.JSON(404, gin.H{"error": "Task not found"})
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+
+	upload := findUploadByID(id)
+	if upload == nil {
+		c.JSON(404, gin.H{"error": "Upload not found"})
 		return
 	}
-
-	if task.DeletedAt == nil {
-		c.JSON(400, gin.H{"error": "Task is not deleted"})
+	if upload.PHash == "" {
+		c.JSON(400, gin.H{"error": "Upload has no perceptual hash"})
 		return
 	}
 
-	maxPos := -1
-	for _, t := range db.Tasks {
-		if t.DeletedAt == nil && t.Position > maxPos {
-			maxPos = t.Position
+	similar := []SimilarImage{}
+	for _, match := range findSimilarImages(upload.PHash, c.Query("task_id"), c.Query("category_id"), maxDistance) {
+		if match.Upload.ID != upload.ID {
+			similar = append(similar, match)
 		}
 	}
-	task.Position = maxPos + 1
-	task.DeletedAt = nil
+	c.JSON(200, similar)
+}
 
-	saveDatabase()
+// @Summary Collect orphaned uploads
+// @Description Delete stored files that no task item references, and their upload records. Files newer than the grace period are kept. Files that are not uploads (not named by content hash and without an upload record) are never deleted and are counted in skipped_foreign.
+// @Tags uploads
+// @Produce json
+// @Param dry_run query bool false "Only report what would be deleted"
+// @Param grace query string false "Grace period, e.g. 24h (default UPLOAD_GC_GRACE)"
+// @Success 200 {object} GCReport
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /uploads/gc [post]
+func collectUploadGarbage(c *gin.Context) {
+	grace := uploadGC.Grace
+	if raw := c.Query("grace"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			c.JSON(400, gin.H{"error": "Invalid grace duration"})
+			return
+		}
+		grace = d
+	}
 
-	response := convertToTaskResponse(*task)
-	c.JSON(200, gin.H{"message": "Task restored", "task": response})
+	report, err := collectGarbage(grace, c.Query("dry_run") == "true")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, report)
 }
 
-// @Summary Permanently delete task
-// @Description Permanently delete a task and all its items
-// @Tags tasks
-// @Produce json
-// @Param id path string true "Task ID"
-// @Success 200 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /tasks/{id}/permanent [delete]
-func permanentDeleteTask(c *gin.Context) {
-	id := c.Param("id")
+// Resumable upload handlers
 
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+// processUpload runs the upload pipeline of the given kind on a file
+func processUpload(kind string, file multipart.File, fileName string) (UploadData, *uploadFailure) {
+	switch kind {
+	case "image":
+		return processImageUpload(file, fileName)
+	case "audio":
+		return processMediaUpload(file, fileName, audioUploadPolicy)
+	default:
+		return processMediaUpload(file, fileName, videoUploadPolicy)
+	}
+}
 
-	task := findTaskByID(id, true)
-	if task == nil {
-		c.JSON(404, gin.H{"error": "Task not found"})
-		return
+// detectUploadKind tells from the content whether a file is an image,
+// audio or video upload, or returns "" if no policy accepts it. Containers
+// both policies take (WebM, MP4) count as video only with a picture track.
+func detectUploadKind(file multipart.File, size int64) string {
+	file.Seek(0, 0)
+	detected, err := mimetype.DetectReader(file)
+	file.Seek(0, 0)
+	if err != nil {
+		return ""
 	}
 
-	items := getTaskItemsByID(id)
-	for _, item := range items {
-		if item.Data != "" {
-			oldPath := strings.TrimPrefix(item.Data, "/static/")
-			os.Remove(filepath.Join("uploads", oldPath))
+	if imageUploadPolicy.find(detected) != nil {
+		return "image"
+	}
+	audio := audioUploadPolicy.find(detected)
+	video := videoUploadPolicy.find(detected)
+	switch {
+	case audio != nil && video != nil:
+		info := probeMedia(file, size, video.Ext)
+		file.Seek(0, 0)
+		if info.Width > 0 {
+			return "video"
 		}
+		return "audio"
+	case audio != nil:
+		return "audio"
+	case video != nil:
+		return "video"
 	}
+	return ""
+}
 
-	newItems := []TaskItem{}
-	for _, item := range db.TaskItems {
-		if item.TaskID != id {
-			newItems = append(newItems, item)
+// processUploadFile runs a multipart file of any kind through its upload
+// pipeline, enforcing the size limit of that kind
+func processUploadFile(header *multipart.FileHeader) (UploadData, string, *uploadFailure) {
+	file, err := header.Open()
+	if err != nil {
+		return UploadData{}, "", &uploadFailure{400, errCodeMissingFile, "Faylni olishda xatolik: " + err.Error()}
+	}
+	defer file.Close()
+
+	kind := detectUploadKind(file, header.Size)
+	if kind == "" {
+		// Let the image policy explain the rejection
+		_, failure := sniffUpload(file, header.Filename, imageUploadPolicy)
+		if failure == nil {
+			failure = &uploadFailure{415, errCodeUnsupportedType, "Fayl turi qo'llab-quvvatlanmaydi"}
 		}
+		return UploadData{}, "", failure
+	}
+	if limit, _ := uploadKindLimit(kind); limit > 0 && header.Size > limit {
+		return UploadData{}, kind, &uploadFailure{413, errCodeTooLarge, fmt.Sprintf("Fayl hajmi ruxsat etilganidan katta (maksimum %d bayt)", limit)}
 	}
-	db.TaskItems = newItems
 
-	deleteTaskByID(id)
-	saveDatabase()
+	data, failure := processUpload(kind, file, header.Filename)
+	return data, kind, failure
+}
 
-	c.JSON(200, gin.H{"message": "Task permanently deleted"})
+// maxUploadBody is the request size limit for endpoints that take files of
+// any kind: the largest per-kind limit, or 0 if any kind is unlimited
+func maxUploadBody() int64 {
+	var limit int64
+	for _, kind := range []string{"image", "audio", "video"} {
+		l, _ := uploadKindLimit(kind)
+		if l == 0 {
+			return 0
+		}
+		limit = max(limit, l)
+	}
+	return limit
 }
 
-// @Summary Mark task as success
-// @Description Update task success status and price
-// @Tags tasks
-// @Accept json
-// @Produce json
-// @Param id path string true "Task ID"
-// @Param success body object{is_success=bool,price=number} true "Success status and price"
-// @Success 200 {object} TaskResponse
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /tasks/{id}/success [put]
-func markTaskSuccess(c *gin.Context) {
-	id := c.Param("id")
+// discardUploads undoes stored uploads that ended up unused. Blobs and
+// records that existed before, or that something else refers to by now,
+// are left alone.
+func discardUploads(uploads []UploadData) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
 
-	var input struct {
-		IsSuccess bool     `json:"is_success"`
-		Price     *float32 `json:"price"`
+	for _, data := range uploads {
+		upload := findUploadByHash(data.Hash)
+		switch {
+		case upload == nil:
+			removeUploadFile(data.URL)
+		case upload.ID == data.ID && !data.Duplicate && upload.RefCount == 0:
+			removeUploadFile(upload.URL)
+			deleteUploadRecord(upload.URL)
+		}
 	}
+	saveDatabase()
+}
 
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+func uploadKindLimit(kind string) (int64, bool) {
+	switch kind {
+	case "image":
+		return uploadLimits.Image, true
+	case "audio":
+		return uploadLimits.Audio, true
+	case "video":
+		return uploadLimits.Video, true
 	}
+	return 0, false
+}
 
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
-
-	task := findTaskByID(id, false)
-	if task == nil {
-		c.JSON(404, gin.H{"error": "Task not found"})
-		return
+func findUploadSession(id string) *UploadSession {
+	for i := range db.UploadSessions {
+		if db.UploadSessions[i].ID == id {
+			return &db.UploadSessions[i]
+		}
 	}
+	return nil
+}
 
-	task.IsSuccess = input.IsSuccess
-	task.Price = input.Price
+func uploadSessionPath(id string) string {
+	return filepath.Join("uploads_tmp", filepath.Base(id)+".part")
+}
 
-	saveDatabase()
+// removeUploadSession drops a session and its partial file
+func removeUploadSession(id string) {
+	for i := range db.UploadSessions {
+		if db.UploadSessions[i].ID == id {
+			db.UploadSessions = append(db.UploadSessions[:i], db.UploadSessions[i+1:]...)
+			break
+		}
+	}
+	os.Remove(uploadSessionPath(id))
 
-	response := convertToTaskResponse(*task)
-	c.JSON(200, response)
+	sessionLocksMu.Lock()
+	delete(sessionLocks, id)
+	sessionLocksMu.Unlock()
 }
 
-// TaskItem handlers
+// lockUploadSession tries to take the session's write lock. The lock is nil
+// for unknown sessions, so made-up ids never get an entry.
+func lockUploadSession(id string) (*sync.Mutex, bool) {
+	// Holding dbMutex keeps the session from being removed (and its entry
+	// dropped) between the lookup and the insert
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
+	if findUploadSession(id) == nil {
+		return nil, false
+	}
 
-// @Summary Create task item
-// @Description Create a new task item
-// @Tags task-items
-// @Accept json
-// @Produce json
-// @Param item body TaskItem true "Task item data"
-// @Success 201 {object} TaskItem
-// @Failure 400 {object} map[string]string
-// @Router /task-items [post]
-func createTaskItem(c *gin.Context) {
-	var item TaskItem
-	if err := c.ShouldBindJSON(&item); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
+	sessionLocksMu.Lock()
+	lock, ok := sessionLocks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		sessionLocks[id] = lock
 	}
+	sessionLocksMu.Unlock()
+	return lock, lock.TryLock()
+}
+
+func setUploadSessionHeaders(c *gin.Context, session UploadSession) {
+	c.Header("Upload-Offset", fmt.Sprintf("%d", session.Offset))
+	c.Header("Upload-Length", fmt.Sprintf("%d", session.Size))
+	c.Header("Upload-Expires", session.ExpiresAt.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", "no-store")
+}
 
+// expireUploadSessions removes sessions that were idle past their expiry
+func expireUploadSessions() {
 	dbMutex.Lock()
-	item.ID = uuid.New().String()
-	item.Time = time.Now()
+	defer dbMutex.Unlock()
 
-	maxPos := 0
-	for _, existingItem := range db.TaskItems {
-		if existingItem.TaskID == item.TaskID && existingItem.Position > maxPos {
-			maxPos = existingItem.Position
+	now := time.Now()
+	var expired []string
+	for _, session := range db.UploadSessions {
+		if now.After(session.ExpiresAt) {
+			expired = append(expired, session.ID)
 		}
 	}
-	item.Position = maxPos + 1
+	for _, id := range expired {
+		removeUploadSession(id)
+	}
+	if len(expired) > 0 {
+		log.Printf("%d ta eskirgan yuklash sessiyasi o'chirildi", len(expired))
+		saveDatabase()
+	}
+}
 
-	db.TaskItems = append(db.TaskItems, item)
-	saveDatabase()
-	dbMutex.Unlock()
+// uploadMainFile maps a stored file name to the upload it belongs to:
+// variants "<id>_<name><ext>" belong to "<id><ext>"
+func uploadMainFile(fileName string) string {
+	ext := filepath.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	if i := strings.LastIndex(base, "_"); i > 0 {
+		return base[:i] + ext
+	}
+	return fileName
+}
 
-	c.JSON(201, item)
+// gcSnapshot is what the garbage collector needs from the database
+type gcSnapshot struct {
+	// referenced holds the files task items point at
+	referenced map[string]bool
+	// registered holds the main file of every upload record
+	registered map[string]bool
+	// heldSince is when an upload was created, or last released or handed
+	// out again; the grace period also runs from then
+	heldSince map[string]time.Time
 }
 
-// @Summary Get all task items
-// @Description Get a list of all task items
-// @Tags task-items
-// @Produce json
-// @Success 200 {array} TaskItem
-// @Router /task-items [get]
-func getTaskItems(c *gin.Context) {
-	dbMutex.RLock()
-	defer dbMutex.RUnlock()
-	c.JSON(200, db.TaskItems)
+// takeGCSnapshot reads the database; the caller holds dbMutex
+func takeGCSnapshot() gcSnapshot {
+	snap := gcSnapshot{
+		referenced: map[string]bool{},
+		registered: map[string]bool{},
+		heldSince:  map[string]time.Time{},
+	}
+	for _, item := range db.TaskItems {
+		urls := []string{item.Data}
+		if upload := findUploadByID(item.UploadID); upload != nil {
+			// Video previews have an image extension, so they are not
+			// found through uploadMainFile
+			urls = []string{upload.URL, upload.Poster, upload.Preview}
+		}
+		for _, url := range urls {
+			if name, ok := blobNameFromURL(url); ok {
+				snap.referenced[name] = true
+			}
+		}
+	}
+	for _, u := range db.Uploads {
+		name, ok := blobNameFromURL(u.URL)
+		if !ok {
+			continue
+		}
+		snap.registered[name] = true
+		held := u.CreatedAt
+		if u.UnreferencedAt != nil && u.UnreferencedAt.After(held) {
+			held = *u.UnreferencedAt
+		}
+		snap.heldSince[name] = held
+	}
+	return snap
 }
 
-// @Summary Get task item by ID
-// @Description Get a single task item by ID
-// @Tags task-items
-// @Produce json
-// @Param id path string true "Task item ID"
-// @Success 200 {object} TaskItem
-// @Failure 404 {object} map[string]string
-// @Router /task-items/{id} [get]
-func getTaskItem(c *gin.Context) {
-	id := c.Param("id")
+// inUse reports whether a blob must be kept regardless of its age
+func (snap gcSnapshot) inUse(name string, cutoff time.Time) bool {
+	main := uploadMainFile(name)
+	return snap.referenced[main] || snap.referenced[name] || snap.heldSince[main].After(cutoff)
+}
+
+// collectGarbage removes blobs that no task item references,
+// together with their upload records. Files modified within grace are kept.
+// Only upload files are considered: content-addressed names, their variants
+// and files of registered uploads. Anything else in the store (e.g. foreign
+// objects in a shared bucket without S3_PREFIX) is left alone.
+// Records whose file no longer exists are dropped as well.
+// With dryRun nothing is deleted and the report lists what would be.
+//
+// The store is listed without holding dbMutex, since with S3 that takes
+// network round-trips. Each candidate is checked again under the lock
+// right before it is deleted, so an upload attached in the meantime stays.
+func collectGarbage(grace time.Duration, dryRun bool) (GCReport, error) {
+	report := GCReport{
+		DryRun:  dryRun,
+		Grace:   grace.String(),
+		Removed: []GCEntry{},
+	}
+
+	dbMutex.RLock()
+	snap := takeGCSnapshot()
+	dbMutex.RUnlock()
+
+	listedAt := time.Now()
+	blobs, err := blobStore.List()
+	if err != nil {
+		return report, err
+	}
+
+	modTimes := map[string]time.Time{}
+	for _, blob := range blobs {
+		modTimes[blob.Name] = blob.ModTime
+	}
+
+	cutoff := time.Now().Add(-grace)
+	collected := map[string]bool{}
+	for _, blob := range blobs {
+		report.ScannedFiles++
+
+		main := uploadMainFile(blob.Name)
+		if !isContentAddressed(blob.Name) && !snap.registered[main] && !snap.registered[blob.Name] {
+			report.SkippedForeign++
+			continue
+		}
+		if snap.referenced[main] || snap.referenced[blob.Name] {
+			continue
+		}
+		// Variants age with their main file, which is touched again when
+		// the same content is uploaded
+		modTime := blob.ModTime
+		if t, ok := modTimes[main]; ok {
+			modTime = t
+		}
+		if modTime.After(cutoff) || snap.heldSince[main].After(cutoff) {
+			report.KeptRecent++
+			continue
+		}
+
+		if !dryRun {
+			kept, err := deleteUnusedBlob(blob.Name, cutoff)
+			if kept {
+				report.KeptRecent++
+				continue
+			}
+			if err != nil {
+				log.Printf("GC: %s o'chirilmadi: %v", blob.Name, err)
+				continue
+			}
+		}
+		collected[blob.Name] = true
+		report.Removed = append(report.Removed, GCEntry{File: blob.Name, Bytes: blob.Size, ModTime: blob.ModTime})
+		report.ReclaimedBytes += blob.Size
+	}
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	snap = takeGCSnapshot()
+	uploads := []Upload{}
+	for _, u := range db.Uploads {
+		fileName, _ := blobNameFromURL(u.URL)
+		_, exists := modTimes[fileName]
+		// Files stored after the listing are not in it
+		missing := !exists && u.CreatedAt.Before(listedAt)
+		if !snap.referenced[fileName] && (collected[fileName] || missing) {
+			report.RemovedRecords++
+			continue
+		}
+		uploads = append(uploads, u)
+	}
+	if !dryRun && report.RemovedRecords > 0 {
+		db.Uploads = uploads
+		saveDatabase()
+	}
+
+	return report, nil
+}
 
-	dbMutex.RLock()
-	defer dbMutex.RUnlock()
+// deleteUnusedBlob deletes a garbage candidate unless a task item or a
+// recent upload claimed it since the snapshot. The lock is held only for
+// this one file, so requests are not blocked for the whole collection.
+func deleteUnusedBlob(name string, cutoff time.Time) (kept bool, err error) {
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
 
-	item := findTaskItemByID(id)
-	if item == nil {
-		c.JSON(404, gin.H{"error": "Task item not found"})
-		return
+	if takeGCSnapshot().inUse(name, cutoff) {
+		return true, nil
 	}
+	return false, blobStore.Delete(name)
+}
 
-	c.JSON(200, item)
+func collectGarbageLoop() {
+	ticker := time.NewTicker(uploadGC.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		report, err := collectGarbage(uploadGC.Grace, false)
+		if err != nil {
+			log.Printf("GC xatolik: %v", err)
+			continue
+		}
+		if len(report.Removed) > 0 || report.RemovedRecords > 0 {
+			log.Printf("GC: %d ta fayl, %d ta yozuv o'chirildi, %d bayt bo'shatildi", len(report.Removed), report.RemovedRecords, report.ReclaimedBytes)
+		}
+	}
 }
 
-// @Summary Get task items by task ID
-// @Description Get all items for a specific task
-// @Tags task-items
-// @Produce json
-// @Param id path string true "Task ID"
-// @Success 200 {array} TaskItem
-// @Router /tasks/{id}/items [get]
-func getTaskItemsByTaskID(c *gin.Context) {
-	taskID := c.Param("id")
+// runGC implements the "gc" command: collect orphaned uploads once and exit.
+// Stop the server first, or use POST /uploads/gc while it is running.
+func runGC(args []string) {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only report what would be deleted")
+	grace := flags.Duration("grace", uploadGC.Grace, "keep files modified within this duration")
+	flags.Parse(args)
 
-	dbMutex.RLock()
-	defer dbMutex.RUnlock()
+	if err := loadDatabase(); err != nil {
+		log.Fatal("Database yuklashda xatolik:", err)
+	}
 
-	items := getTaskItemsByID(taskID)
-	c.JSON(200, items)
+	report, err := collectGarbage(*grace, *dryRun)
+	if err != nil {
+		log.Fatal("GC xatolik:", err)
+	}
+
+	for _, entry := range report.Removed {
+		fmt.Printf("%s\t%d\t%s\n", entry.File, entry.Bytes, entry.ModTime.Format(time.RFC3339))
+	}
+	verb := "o'chirildi"
+	if report.DryRun {
+		verb = "o'chiriladi (dry run)"
+	}
+	fmt.Printf("%d ta fayl tekshirildi, %d ta yangi fayl qoldirildi, %d ta begona fayl o'tkazib yuborildi\n", report.ScannedFiles, report.KeptRecent, report.SkippedForeign)
+	fmt.Printf("%d ta fayl va %d ta yozuv %s, %d bayt\n", len(report.Removed), report.RemovedRecords, verb, report.ReclaimedBytes)
 }
 
-// @Summary Update task item
-// @Description Update an existing task item
-// @Tags task-items
+func expireUploadSessionsLoop() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		expireUploadSessions()
+		<-ticker.C
+	}
+}
+
+// @Summary Create resumable upload
+// @Description Start a resumable upload session. Send the file with PATCH requests, then finalize it.
+// @Tags uploads
 // @Accept json
 // @Produce json
-// @Param id path string true "Task item ID"
-// @Param item body TaskItem true "Updated task item data"
-// @Success 200 {object} TaskItem
-// @Failure 400 {object} map[string]string
-// @Failure 404 {object} map[string]string
-// @Router /task-items/{id} [put]
-func updateTaskItem(c *gin.Context) {
-	id := c.Param("id")
+// @Param X-User-ID header string false "Uploader, charged against the user quota"
+// @Param session body object{kind=string,file_name=string,size=int,category_id=string} true "kind is image, audio or video; size is the total file size in bytes"
+// @Success 201 {object} UploadSession
+// @Failure 400 {object} UploadResponse
+// @Failure 413 {object} UploadResponse
+// @Router /uploads/sessions [post]
+func createUploadSession(c *gin.Context) {
+	var input struct {
+		Kind       string `json:"kind"`
+		FileName   string `json:"file_name"`
+		Size       int64  `json:"size"`
+		CategoryID string `json:"category_id"`
+	}
 
-	var input TaskItem
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+		c.JSON(400, UploadResponse{Success: false, StatusCode: 400, Message: err.Error()})
+		return
+	}
+
+	if input.Kind == "" {
+		input.Kind = "video"
+	}
+	limit, ok := uploadKindLimit(input.Kind)
+	if !ok {
+		c.JSON(400, UploadResponse{Success: false, StatusCode: 400, Message: "kind image, audio yoki video bo'lishi kerak"})
+		return
+	}
+	if input.Size <= 0 {
+		c.JSON(400, UploadResponse{Success: false, StatusCode: 400, Message: "size musbat bo'lishi kerak"})
+		return
+	}
+	if limit > 0 && input.Size > limit {
+		(&uploadFailure{413, errCodeTooLarge, fmt.Sprintf("Fayl hajmi ruxsat etilganidan katta (maksimum %d bayt)", limit)}).respond(c)
 		return
 	}
 
+	ownerID := currentUserID(c)
+
 	dbMutex.Lock()
 	defer dbMutex.Unlock()
 
-	item := findTaskItemByID(id)
-	if item == nil {
-		c.JSON(404, gin.H{"error": "Task item not found"})
+	if failure := checkQuota(ownerID, input.CategoryID, input.Size); failure != nil {
+		failure.respond(c)
 		return
 	}
 
-	item.Type = input.Type
-	item.Data = input.Data
-	item.TaskID = input.TaskID
+	now := time.Now()
+	session := UploadSession{
+		ID:         uuid.New().String(),
+		Kind:       input.Kind,
+		FileName:   input.FileName,
+		Size:       input.Size,
+		OwnerID:    ownerID,
+		CategoryID: input.CategoryID,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(uploadSessionTTL),
+	}
+
+	part, err := os.Create(uploadSessionPath(session.ID))
+	if err != nil {
+		(&uploadFailure{500, errCodeStorage, "Faylni saqlashda xatolik: " + err.Error()}).respond(c)
+		return
+	}
+	part.Close()
 
+	db.UploadSessions = append(db.UploadSessions, session)
 	saveDatabase()
-	c.JSON(200, item)
+
+	setUploadSessionHeaders(c, session)
+	c.Header("Location", "/uploads/sessions/"+session.ID)
+	c.JSON(201, session)
 }
 
-// @Summary Delete task item
-// @Description Delete a task item and its associated file
-// @Tags task-items
+// @Summary Get resumable upload offset
+// @Description Get the current offset of an upload session (also returned in the Upload-Offset header, HEAD works too)
+// @Tags uploads
 // @Produce json
-// @Param id path string true "Task item ID"
-// @Success 200 {object} map[string]string
+// @Param id path string true "Session ID"
+// @Success 200 {object} UploadSession
 // @Failure 404 {object} map[string]string
-// @Router /task-items/{id} [delete]
-func deleteTaskItem(c *gin.Context) {
+// @Router /uploads/sessions/{id} [get]
+func getUploadSession(c *gin.Context) {
 	id := c.Param("id")
 
-	dbMutex.Lock()
-	defer dbMutex.Unlock()
+	dbMutex.RLock()
+	defer dbMutex.RUnlock()
 
-	item := findTaskItemByID(id)
-	if item == nil {
-		c.JSON(404, gin.H{"error": "Task item not found"})
+	session := findUploadSession(id)
+	if session == nil {
+		c.JSON(404, gin.H{"error": "Upload session not found"})
 		return
 	}
 
-	if item.Data != "" {
-		oldPath := strings.TrimPrefix(item.Data, "/static/")
-		os.Remove(filepath.Join("uploads", oldPath))
+	setUploadSessionHeaders(c, *session)
+	if c.Request.Method == http.MethodHead {
+		c.Status(200)
+		return
 	}
-
-	deleteTaskItemByID(id)
-	saveDatabase()
-
-	c.JSON(200, gin.H{"message": "Task item deleted"})
+	c.JSON(200, session)
 }
 
-// File upload handlers
-
-// @Summary Upload image
-// @Description Upload an image file (JPEG, PNG, GIF, BMP, TIFF, WebP). HEIC/HEIF not supported.
+// @Summary Upload a chunk
+// @Description Append bytes to an upload session. Upload-Offset must equal the current offset. If the connection drops, the bytes received so far are kept; ask for the offset and resume from there.
 // @Tags uploads
-// @Accept multipart/form-data
-// @Produce json
-// @Param image formData file true "Image file"
-// @Success 200 {object} UploadResponse
-// @Failure 400 {object} UploadResponse
-// @Failure 500 {object} UploadResponse
-// @Router /upload/image [post]
-func uploadImage(c *gin.Context) {
-	file, handler, err := c.Request.FormFile("image")
-	if err != nil {
-		c.JSON(400, UploadResponse{
-			Success:    false,
-			StatusCode: 400,
-			Message:    "Rasmni olishda xatolik: " + err.Error(),
-		})
+// @Accept application/offset+octet-stream
+// @Param id path string true "Session ID"
+// @Param Upload-Offset header int true "Offset this chunk starts at"
+// @Success 204
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]interface{}
+// @Failure 423 {object} map[string]string
+// @Router /uploads/sessions/{id} [patch]
+func patchUploadSession(c *gin.Context) {
+	id := c.Param("id")
+
+	var offset int64
+	if _, err := fmt.Sscanf(c.GetHeader("Upload-Offset"), "%d", &offset); err != nil {
+		c.JSON(400, gin.H{"error": "Upload-Offset header is required"})
 		return
 	}
-	defer file.Close()
 
-	originalExt := strings.ToLower(filepath.Ext(handler.Filename))
-
-	img, format, err := decodeImage(file, originalExt)
-	if err != nil {
-		c.JSON(400, UploadResponse{
-			Success:    false,
-			StatusCode: 400,
-			Message:    "Rasmni o'qishda xatolik: " + err.Error(),
-		})
+	lock, ok := lockUploadSession(id)
+	if lock == nil {
+		c.JSON(404, gin.H{"error": "Upload session not found"})
 		return
 	}
-
-	log.Printf("Image decoded successfully. Format: %s, Original ext: %s", format, originalExt)
-
-	fileID := uuid.New().String()
-
-	saveExt := originalExt
-	contentType := ""
-
-	switch originalExt {
-	case ".jpg", ".jpeg":
-		saveExt = ".jpg"
-		contentType = "image/jpeg"
-	case ".png":
-		saveExt = ".png"
-		contentType = "image/png"
-	case ".gif":
-		saveExt = ".gif"
-		contentType = "image/gif"
-	case ".bmp":
-		saveExt = ".bmp"
-		contentType = "image/bmp"
-	case ".tiff", ".tif":
-		saveExt = ".tiff"
-		contentType = "image/tiff"
-	case ".webp":
-		saveExt = ".jpg"
-		contentType = "image/jpeg"
-	case ".heic", ".heif":
-		saveExt = ".jpg"
-		contentType = "image/jpeg"
-	default:
-		saveExt = ".jpg"
-		contentType = "image/jpeg"
+	if !ok {
+		c.JSON(423, gin.H{"error": "Another chunk is being written to this session"})
+		return
 	}
+	defer lock.Unlock()
 
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	if width > 2048 {
-		img = resize.Resize(2048, 0, img, resize.Lanczos3)
-		log.Println("Image resized to 2048px width")
+	dbMutex.RLock()
+	session := findUploadSession(id)
+	var current UploadSession
+	if session != nil {
+		current = *session
 	}
+	dbMutex.RUnlock()
 
-	savePath := fmt.Sprintf("uploads/%s%s", fileID, saveExt)
-
-	err = saveImage(img, savePath, saveExt)
-	if err != nil {
-		c.JSON(500, UploadResponse{
-			Success:    false,
-			StatusCode: 500,
-			Message:    "Rasmni saqlashda xatolik: " + err.Error(),
-		})
+	if session == nil {
+		c.JSON(404, gin.H{"error": "Upload session not found"})
 		return
 	}
-
-	fileInfo, err := os.Stat(savePath)
-	if err != nil {
-		c.JSON(500, UploadResponse{
-			Success:    false,
-			StatusCode: 500,
-			Message:    "Fayl ma'lumotlarini olishda xatolik: " + err.Error(),
-		})
+	if offset != current.Offset {
+		setUploadSessionHeaders(c, current)
+		c.JSON(409, gin.H{"error": "Upload-Offset does not match the session offset", "offset": current.Offset})
 		return
 	}
-	fileSize := fileInfo.Size()
-
-	imageURL := fmt.Sprintf("/static/%s%s", fileID, saveExt)
-
-	c.JSON(200, UploadResponse{
-		Success:    true,
-		StatusCode: 200,
-		Message:    "Rasm muvaffaqiyatli yuklandi",
-		Data: UploadData{
-			ID:          fileID,
-			Size:        fileSize,
-			URL:         imageURL,
-			FileName:    handler.Filename,
-			ContentType: contentType,
-			DurationMs:  nil,
-		},
-	})
-}
 
-// @Summary Upload audio
-// @Description Upload an audio file in any format
-// @Tags uploads
-// @Accept multipart/form-data
-// @Produce json
-// @Param audio formData file true "Audio file"
-// @Success 200 {object} UploadResponse
-// @Failure 400 {object} UploadResponse
-// @Failure 500 {object} UploadResponse
-// @Router /upload/audio [post]
-func uploadAudio(c *gin.Context) {
-	file, handler, err := c.Request.FormFile("audio")
+	part, err := os.OpenFile(uploadSessionPath(id), os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		c.JSON(400, UploadResponse{
-			Success:    false,
-			StatusCode: 400,
-			Message:    "Audioni olishda xatolik: " + err.Error(),
-		})
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	defer file.Close()
-
-	fileID := uuid.New().String()
-	ext := strings.ToLower(filepath.Ext(handler.Filename))
-
-	if ext == "" {
-		ext = ".mp3"
+	// If the partial file lost data (e.g. uploads_tmp was wiped), rewind
+	// the session so the client resumes from what is actually on disk
+	if info, err := part.Stat(); err == nil && info.Size() < offset {
+		part.Close()
+		dbMutex.Lock()
+		if session = findUploadSession(id); session != nil {
+			session.Offset = info.Size()
+			current = *session
+			saveDatabase()
+		}
+		dbMutex.Unlock()
+		setUploadSessionHeaders(c, current)
+		c.JSON(409, gin.H{"error": "Upload-Offset does not match the session offset", "offset": current.Offset})
+		return
 	}
 
-	savePath := fmt.Sprintf("uploads/%s%s", fileID, ext)
+	// Drop anything past the recorded offset left by an interrupted write
+	part.Truncate(offset)
+	part.Seek(offset, io.SeekStart)
 
-	if err := c.SaveUploadedFile(handler, savePath); err != nil {
-		c.JSON(500, UploadResponse{
-			Success:    false,
-			StatusCode: 500,
-			Message:    "Faylni saqlashda xatolik: " + err.Error(),
-		})
-		return
-	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, current.Size-offset)
+	written, copyErr := io.Copy(part, body)
+	part.Close()
 
-	fileInfo, err := os.Stat(savePath)
-	if err != nil {
-		c.JSON(500, UploadResponse{
-			Success:    false,
-			StatusCode: 500,
-			Message:    "Fayl ma'lumotlarini olishda xatolik: " + err.Error(),
-		})
+	dbMutex.Lock()
+	session = findUploadSession(id)
+	if session == nil {
+		dbMutex.Unlock()
+		c.JSON(404, gin.H{"error": "Upload session not found"})
 		return
 	}
-	fileSize := fileInfo.Size()
+	session.Offset = offset + written
+	session.ExpiresAt = time.Now().Add(uploadSessionTTL)
+	current = *session
+	saveDatabase()
+	dbMutex.Unlock()
 
-	audioURL := fmt.Sprintf("/static/%s%s", fileID, ext)
+	setUploadSessionHeaders(c, current)
 
-	contentType := "audio/mpeg"
-	switch ext {
-	case ".mp3":
-		contentType = "audio/mpeg"
-	case ".wav":
-		contentType = "audio/wav"
-	case ".ogg":
-		contentType = "audio/ogg"
-	case ".m4a":
-		contentType = "audio/mp4"
-	case ".aac":
-		contentType = "audio/aac"
-	case ".flac":
-		contentType = "audio/flac"
-	case ".wma":
-		contentType = "audio/x-ms-wma"
-	default:
-		contentType = "audio/mpeg"
+	var tooLarge *http.MaxBytesError
+	if errors.As(copyErr, &tooLarge) {
+		c.JSON(413, gin.H{"error": "Chunk exceeds the declared upload size", "offset": current.Offset})
+		return
+	}
+	if copyErr != nil {
+		c.JSON(400, gin.H{"error": copyErr.Error(), "offset": current.Offset})
+		return
 	}
 
-	c.JSON(200, UploadResponse{
-		Success:    true,
-		StatusCode: 200,
-		Message:    "Audio muvaffaqiyatli yuklandi",
-		Data: UploadData{
-			ID:          fileID,
-			Size:        fileSize,
-			URL:         audioURL,
-			FileName:    handler.Filename,
-			ContentType: contentType,
-			DurationMs:  nil,
-		},
-	})
+	c.Status(204)
 }
 
-// @Summary Upload video
-// @Description Upload a video file in any format
+// @Summary Finalize resumable upload
+// @Description Process a fully uploaded session like a regular upload and return the same UploadResponse
 // @Tags uploads
-// @Accept multipart/form-data
 // @Produce json
-// @Param video formData file true "Video file"
+// @Param id path string true "Session ID"
 // @Success 200 {object} UploadResponse
 // @Failure 400 {object} UploadResponse
-// @Failure 500 {object} UploadResponse
-// @Router /upload/video [post]
-func uploadVideo(c *gin.Context) {
-	file, handler, err := c.Request.FormFile("video")
-	if err != nil {
-		c.JSON(400, UploadResponse{
-			Success:    false,
-			StatusCode: 400,
-			Message:    "Videoni olishda xatolik: " + err.Error(),
-		})
+// @Failure 404 {object} UploadResponse
+// @Failure 409 {object} UploadResponse
+// @Failure 413 {object} UploadResponse
+// @Failure 415 {object} UploadResponse
+// @Router /uploads/sessions/{id}/finalize [post]
+func finalizeUploadSession(c *gin.Context) {
+	id := c.Param("id")
+
+	lock, ok := lockUploadSession(id)
+	if lock == nil {
+		c.JSON(404, UploadResponse{Success: false, StatusCode: 404, Message: "Yuklash sessiyasi topilmadi"})
 		return
 	}
-	defer file.Close()
-
-	fileID := uuid.New().String()
-	ext := strings.ToLower(filepath.Ext(handler.Filename))
-
-	if ext == "" {
-		ext = ".mp4"
+	if !ok {
+		c.JSON(423, UploadResponse{Success: false, StatusCode: 423, Message: "Sessiyaga hozir yozilmoqda"})
+		return
 	}
+	defer lock.Unlock()
 
-	savePath := fmt.Sprintf("uploads/%s%s", fileID, ext)
+	dbMutex.RLock()
+	session := findUploadSession(id)
+	var current UploadSession
+	if session != nil {
+		current = *session
+	}
+	dbMutex.RUnlock()
 
-	if err := c.SaveUploadedFile(handler, savePath); err != nil {
-		c.JSON(500, UploadResponse{
+	if session == nil {
+		c.JSON(404, UploadResponse{Success: false, StatusCode: 404, Message: "Yuklash sessiyasi topilmadi"})
+		return
+	}
+	if current.Offset != current.Size {
+		setUploadSessionHeaders(c, current)
+		c.JSON(409, UploadResponse{
 			Success:    false,
-			StatusCode: 500,
-			Message:    "Faylni saqlashda xatolik: " + err.Error(),
+			StatusCode: 409,
+			Message:    fmt.Sprintf("Fayl to'liq yuklanmagan (%d / %d bayt)", current.Offset, current.Size),
 		})
 		return
 	}
 
-	fileInfo, err := os.Stat(savePath)
+	part, err := os.Open(uploadSessionPath(id))
 	if err != nil {
-		c.JSON(500, UploadResponse{
-			Success:    false,
-			StatusCode: 500,
-			Message:    "Fayl ma'lumotlarini olishda xatolik: " + err.Error(),
-		})
+		(&uploadFailure{500, errCodeStorage, "Faylni o'qishda xatolik: " + err.Error()}).respond(c)
+		return
+	}
+	data, failure := processUpload(current.Kind, part, current.FileName)
+	part.Close()
+	if failure != nil {
+		failure.respond(c)
 		return
 	}
-	fileSize := fileInfo.Size()
-
-	videoURL := fmt.Sprintf("/static/%s%s", fileID, ext)
 
-	contentType := "video/mp4"
-	switch ext {
-	case ".mp4":
-		contentType = "video/mp4"
-	case ".mov":
-		contentType = "video/quicktime"
-	case ".avi":
-		contentType = "video/x-msvideo"
-	case ".webm":
-		contentType = "video/webm"
-	case ".mkv":
-		contentType = "video/x-matroska"
-	case ".flv":
-		contentType = "video/x-flv"
-	case ".wmv":
-		contentType = "video/x-ms-wmv"
-	case ".m4v":
-		contentType = "video/x-m4v"
-	default:
-		contentType = "video/mp4"
+	if failure := recordUpload(&data, current.OwnerID, current.CategoryID); failure != nil {
+		failure.respond(c)
+		return
 	}
 
+	dbMutex.Lock()
+	removeUploadSession(id)
+	saveDatabase()
+	dbMutex.Unlock()
+
+	signUploadData(&data)
 	c.JSON(200, UploadResponse{
 		Success:    true,
 		StatusCode: 200,
-		Message:    "Video muvaffaqiyatli yuklandi",
-		Data: UploadData{
-			ID:          fileID,
-			Size:        fileSize,
-			URL:         videoURL,
-			FileName:    handler.Filename,
-			ContentType: contentType,
-			DurationMs:  nil,
-		},
+		Message:    "Fayl muvaffaqiyatli yuklandi",
+		Data:       data,
 	})
 }
+
+// @Summary Abort resumable upload
+// @Description Delete an upload session and the bytes uploaded so far
+// @Tags uploads
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /uploads/sessions/{id} [delete]
+func deleteUploadSession(c *gin.Context) {
+	id := c.Param("id")
+
+	dbMutex.Lock()
+	defer dbMutex.Unlock()
+
+	if findUploadSession(id) == nil {
+		c.JSON(404, gin.H{"error": "Upload session not found"})
+		return
+	}
+
+	removeUploadSession(id)
+	saveDatabase()
+	c.JSON(200, gin.H{"message": "Upload session deleted"})
+}
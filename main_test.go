@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrium/goheif"
+)
+
+// memFile serves an in-memory fixture as a multipart.File
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error { return nil }
+
+// heicFixture loads testdata/orientation.heic, a 320x240 HEIC whose Exif
+// item carries orientation 6, and rewrites the orientation tag to the given
+// value
+func heicFixture(t *testing.T, orientation uint16) memFile {
+	t.Helper()
+	data, err := os.ReadFile("testdata/orientation.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Big-endian IFD entry: tag 0x0112, type SHORT, count 1
+	entry := []byte{0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01}
+	i := bytes.Index(data, entry)
+	if i < 0 {
+		t.Fatal("orientation tag not found in fixture")
+	}
+	data[i+8] = byte(orientation >> 8)
+	data[i+9] = byte(orientation)
+
+	return memFile{bytes.NewReader(data)}
+}
+
+func TestDecodeHEIC(t *testing.T) {
+	goheif.SafeEncoding = true
+
+	// The extension is deliberately wrong: iPhone photos are often renamed
+	// to .jpg, so HEIC must be detected from the ftyp brand
+	for _, ext := range []string{".heic", ".jpg"} {
+		img, format, err := decodeImage(heicFixture(t, 1), ext)
+		if err != nil {
+			t.Fatalf("%s: decode: %v", ext, err)
+		}
+		if format != "heic" {
+			t.Errorf("%s: format = %q, want heic", ext, format)
+		}
+		if b := img.Bounds(); b.Dx() != 320 || b.Dy() != 240 {
+			t.Errorf("%s: size = %dx%d, want 320x240", ext, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestHEICOrientation(t *testing.T) {
+	goheif.SafeEncoding = true
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		file := heicFixture(t, uint16(orientation))
+		img, format, err := decodeImage(file, ".heic")
+		if err != nil {
+			t.Fatalf("orientation %d: decode: %v", orientation, err)
+		}
+
+		meta := readImageMetadata(file, format)
+		if meta.Orientation != orientation {
+			t.Fatalf("orientation = %d, want %d", meta.Orientation, orientation)
+		}
+
+		wantW, wantH := 320, 240
+		if orientation >= 5 {
+			wantW, wantH = 240, 320
+		}
+		b := applyOrientation(img, meta.Orientation).Bounds()
+		if b.Dx() != wantW || b.Dy() != wantH {
+			t.Errorf("orientation %d: size = %dx%d, want %dx%d",
+				orientation, b.Dx(), b.Dy(), wantW, wantH)
+		}
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// Source image, one grey level per pixel:
+	//   a b c
+	//   d e f
+	const a, b, c, d, e, f = 10, 20, 30, 40, 50, 60
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i, v := range []uint8{a, b, c, d, e, f} {
+		src.Set(i%3, i/3, color.RGBA{v, v, v, 255})
+	}
+
+	tests := []struct {
+		orientation int
+		want        [][]uint8
+	}{
+		{0, [][]uint8{{a, b, c}, {d, e, f}}},
+		{1, [][]uint8{{a, b, c}, {d, e, f}}},
+		{2, [][]uint8{{c, b, a}, {f, e, d}}},
+		{3, [][]uint8{{f, e, d}, {c, b, a}}},
+		{4, [][]uint8{{d, e, f}, {a, b, c}}},
+		{5, [][]uint8{{a, d}, {b, e}, {c, f}}},
+		{6, [][]uint8{{d, a}, {e, b}, {f, c}}},
+		{7, [][]uint8{{f, c}, {e, b}, {d, a}}},
+		{8, [][]uint8{{c, f}, {b, e}, {a, d}}},
+		{9, [][]uint8{{a, b, c}, {d, e, f}}},
+	}
+
+	for _, tt := range tests {
+		img := applyOrientation(src, tt.orientation)
+		bounds := img.Bounds()
+		if bounds.Dx() != len(tt.want[0]) || bounds.Dy() != len(tt.want) {
+			t.Errorf("orientation %d: size = %dx%d, want %dx%d", tt.orientation,
+				bounds.Dx(), bounds.Dy(), len(tt.want[0]), len(tt.want))
+			continue
+		}
+		for y, row := range tt.want {
+			for x, want := range row {
+				r, _, _, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+				if got := uint8(r >> 8); got != want {
+					t.Errorf("orientation %d: pixel (%d,%d) = %d, want %d",
+						tt.orientation, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// Builders for the minimal containers used by the probe tests
+
+func le16(v uint16) []byte { return binary.LittleEndian.AppendUint16(nil, v) }
+func le32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func riffChunk(id string, payload []byte) []byte {
+	chunk := concat([]byte(id), le32(uint32(len(payload))), payload)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func wavFile(format uint16, byteRate uint32, dataSize int, extra ...[]byte) []byte {
+	fmtChunk := riffChunk("fmt ", concat(le16(format), le16(1), le32(8000), le32(byteRate), le16(2), le16(16)))
+	body := concat(append(append([][]byte{[]byte("WAVE")}, extra...), fmtChunk, riffChunk("data", make([]byte, dataSize)))...)
+	return concat([]byte("RIFF"), le32(uint32(len(body))), body)
+}
+
+func mp4Box(boxType string, payload ...[]byte) []byte {
+	body := concat(payload...)
+	return concat(be32(uint32(8+len(body))), []byte(boxType), body)
+}
+
+// mp4Track builds a trak with a version 0 tkhd; rotated sets a 90 degree
+// display matrix
+func mp4Track(handler, codec string, width, height uint32, rotated bool) []byte {
+	tkhd := make([]byte, 84)
+	matrix := tkhd[40:]
+	if rotated {
+		copy(matrix[4:], be32(0x00010000))
+		copy(matrix[12:], be32(0xFFFF0000))
+	} else {
+		copy(matrix, be32(0x00010000))
+		copy(matrix[16:], be32(0x00010000))
+	}
+	copy(tkhd[76:], be32(width<<16))
+	copy(tkhd[80:], be32(height<<16))
+
+	hdlr := concat(make([]byte, 8), []byte(handler), make([]byte, 13))
+	stsd := concat(make([]byte, 4), be32(1), be32(16), []byte(codec), make([]byte, 8))
+	return mp4Box("trak",
+		mp4Box("tkhd", tkhd),
+		mp4Box("mdia", mp4Box("hdlr", hdlr), mp4Box("minf", mp4Box("stbl", mp4Box("stsd", stsd)))),
+	)
+}
+
+func mp4Movie(timescale, duration uint32, tracks ...[]byte) []byte {
+	mvhd := concat(make([]byte, 12), be32(timescale), be32(duration), make([]byte, 80))
+	return concat(
+		mp4Box("ftyp", []byte("isom"), be32(0), []byte("isom")),
+		mp4Box("mdat", make([]byte, 32)),
+		mp4Box("moov", append([][]byte{mp4Box("mvhd", mvhd)}, tracks...)...),
+	)
+}
+
+func oggPage(granule uint64, lacing []byte, payload []byte) []byte {
+	return concat([]byte("OggS"), []byte{0, 2},
+		binary.LittleEndian.AppendUint64(nil, granule),
+		le32(1), le32(0), le32(0), []byte{byte(len(lacing))}, lacing, payload)
+}
+
+func ebmlElement(id uint32, payload ...[]byte) []byte {
+	var idBytes []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(idBytes) > 0 {
+			idBytes = append(idBytes, b)
+		}
+	}
+	body := concat(payload...)
+	size := binary.BigEndian.AppendUint64(nil, uint64(len(body))|1<<56)[1:]
+	return concat(idBytes, []byte{0x01}, size, body)
+}
+
+func ebmlUintBytes(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func TestProbeMedia(t *testing.T) {
+	opusHead := concat([]byte("OpusHead"), []byte{1, 1}, le16(312), le32(48000), le16(0), []byte{0})
+	vorbisID := concat([]byte("\x01vorbis"), le32(0), []byte{1}, le32(44100), make([]byte, 14))
+
+	// An ID3 tag whose size (0x0A) gives the wrong offset when the
+	// syncsafe bytes are OR-ed into 10 instead of added; its body holds a
+	// 64 kbps frame header that must be skipped
+	id3 := concat([]byte("ID3"), []byte{4, 0, 0, 0, 0, 0, 0x0A}, []byte{0xFF, 0xFB, 0x50, 0x00}, make([]byte, 6))
+	mp3Frame := []byte{0xFF, 0xFB, 0x90, 0x00} // MPEG-1 Layer III, 128 kbps, 44.1 kHz, stereo
+	xing := concat(mp3Frame, make([]byte, 32), []byte("Xing"), be32(1), be32(38), make([]byte, 200))
+
+	info := ebmlElement(ebmlInfo,
+		ebmlElement(ebmlTimecodeScale, ebmlUintBytes(1000000)),
+		ebmlElement(ebmlDuration, binary.BigEndian.AppendUint64(nil, math.Float64bits(1500))),
+	)
+	tracks := ebmlElement(ebmlTracks,
+		ebmlElement(ebmlTrackEntry,
+			ebmlElement(ebmlTrackType, []byte{1}),
+			ebmlElement(ebmlCodecID, []byte("V_VP8")),
+			ebmlElement(ebmlVideo, ebmlElement(ebmlPixelWidth, ebmlUintBytes(320)), ebmlElement(ebmlPixelHeight, ebmlUintBytes(240))),
+		),
+		ebmlElement(ebmlTrackEntry,
+			ebmlElement(ebmlTrackType, []byte{2}),
+			ebmlElement(ebmlCodecID, []byte("A_OPUS")),
+		),
+	)
+	audioTracks := ebmlElement(ebmlTracks, ebmlElement(ebmlTrackEntry,
+		ebmlElement(ebmlTrackType, []byte{2}),
+		ebmlElement(ebmlCodecID, []byte("A_OPUS")),
+	))
+	ebmlHeader := ebmlElement(0x1A45DFA3, ebmlElement(0x4282, []byte("webm")))
+	cluster := ebmlElement(ebmlCluster,
+		ebmlElement(ebmlClusterTime, ebmlUintBytes(1000)),
+		ebmlElement(ebmlSimpleBlock, []byte{0x81}, be32(500)[2:], []byte{0x80}),
+	)
+
+	tests := []struct {
+		name          string
+		ext           string
+		data          []byte
+		codec         string
+		durationMs    int
+		width, height int
+	}{
+		{name: "wav pcm", ext: ".wav", data: wavFile(1, 16000, 16000), codec: "pcm", durationMs: 1000},
+		{name: "wav skips chunks before fmt", ext: ".wav", data: wavFile(3, 32000, 8000, riffChunk("LIST", []byte("odd"))), codec: "pcm_float", durationMs: 250},
+		{name: "wav without data", ext: ".wav", data: wavFile(1, 16000, 0)[:36], codec: "pcm"},
+		{name: "wav truncated", ext: ".wav", data: []byte("RIFF\x00\x00\x00\x00WAVEfmt ")},
+		{name: "mp3 cbr after id3", ext: ".mp3", data: concat(id3, mp3Frame, make([]byte, 16000-4)), codec: "mp3", durationMs: 1000},
+		{name: "mp3 cbr with id3v1", ext: ".mp3", data: concat(mp3Frame, make([]byte, 16000-4), []byte("TAG"), make([]byte, 125)), codec: "mp3", durationMs: 1000},
+		{name: "mp3 xing", ext: ".mp3", data: xing, codec: "mp3", durationMs: 993},
+		{name: "mp3 no frame", ext: ".mp3", data: make([]byte, 100), codec: "mp3"},
+		{name: "mp4 video", ext: ".mp4", data: mp4Movie(1000, 2500, mp4Track("soun", "mp4a", 0, 0, false), mp4Track("vide", "avc1", 640, 480, false)), codec: "avc1", durationMs: 2500, width: 640, height: 480},
+		{name: "mp4 rotated", ext: ".mov", data: mp4Movie(600, 300, mp4Track("vide", "hvc1", 1920, 1080, true)), codec: "hvc1", durationMs: 500, width: 1080, height: 1920},
+		{name: "m4a audio", ext: ".m4a", data: mp4Movie(44100, 44100, mp4Track("soun", "mp4a", 0, 0, false)), codec: "mp4a", durationMs: 1000},
+		{name: "mp4 box past end", ext: ".mp4", data: concat(be32(0x7FFFFFF0), []byte("mdat"), make([]byte, 8))},
+		{name: "mp4 huge largesize", ext: ".mp4", data: concat(be32(1), []byte("mdat"), binary.BigEndian.AppendUint64(nil, 1<<63-1), make([]byte, 8))},
+		{name: "ogg opus", ext: ".ogg", data: concat(oggPage(0, []byte{19}, opusHead), oggPage(48312, []byte{0}, nil)), codec: "opus", durationMs: 1000},
+		{name: "ogg vorbis", ext: ".ogg", data: concat(oggPage(0, []byte{30}, vorbisID), oggPage(22050, []byte{0}, nil)), codec: "vorbis", durationMs: 500},
+		{name: "ogg segment table past end", ext: ".ogg", data: concat([]byte("OggS"), make([]byte, 22), []byte{200, 0}, opusHead, make([]byte, 64-28-len(opusHead)))},
+		{name: "ogg packet past end", ext: ".ogg", data: oggPage(0, []byte{255, 255, 19}, opusHead)},
+		{name: "webm with duration", ext: ".webm", data: concat(ebmlHeader, ebmlElement(ebmlSegment, info, tracks)), codec: "vp8", durationMs: 1500, width: 320, height: 240},
+		{name: "webm duration from clusters", ext: ".webm", data: concat(ebmlHeader, ebmlElement(ebmlSegment, audioTracks, cluster)), codec: "opus", durationMs: 1500},
+		{name: "webm truncated", ext: ".webm", data: concat(ebmlHeader, ebmlElement(ebmlSegment, info, tracks))[:40]},
+		{name: "unknown extension", ext: ".flac", data: []byte("fLaC")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := probeMedia(bytes.NewReader(tt.data), int64(len(tt.data)), tt.ext)
+
+			durationMs := 0
+			if info.DurationMs != nil {
+				durationMs = *info.DurationMs
+			}
+			if info.Codec != tt.codec || durationMs != tt.durationMs || info.Width != tt.width || info.Height != tt.height {
+				t.Errorf("got codec %q, %d ms, %dx%d; want codec %q, %d ms, %dx%d",
+					info.Codec, durationMs, info.Width, info.Height,
+					tt.codec, tt.durationMs, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestImageTransformCover(t *testing.T) {
+	// Left half red, right half blue
+	wide := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 100; y++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= 200 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			wide.Set(x, y, c)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		src           image.Image
+		width, height int
+	}{
+		{"wide source", wide, 50, 50},
+		{"extreme aspect ratio", image.NewRGBA(image.Rect(0, 0, 10, 4000)), 512, 512},
+		{"upscale", image.NewRGBA(image.Rect(0, 0, 3, 2)), 300, 100},
+	}
+	for _, tt := range tests {
+		img := imageTransform{Width: tt.width, Height: tt.height, Fit: "cover"}.apply(tt.src)
+		if b := img.Bounds(); b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("%s: size = %dx%d, want %dx%d", tt.name, b.Dx(), b.Dy(), tt.width, tt.height)
+		}
+	}
+
+	// The centre crop of the wide image keeps both halves
+	img := imageTransform{Width: 50, Height: 50, Fit: "cover"}.apply(wide)
+	if r, _, b, _ := img.At(5, 25).RGBA(); r>>8 < 200 || b>>8 > 50 {
+		t.Errorf("left edge is not red: r=%d b=%d", r>>8, b>>8)
+	}
+	if r, _, b, _ := img.At(44, 25).RGBA(); b>>8 < 200 || r>>8 > 50 {
+		t.Errorf("right edge is not blue: r=%d b=%d", r>>8, b>>8)
+	}
+}
+
+func TestQuantizeImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		src.Set(x, 0, color.NRGBA{uint8(x), uint8(x), uint8(x), uint8(x)})
+	}
+
+	dst := quantizeImage(src).(*image.NRGBA)
+	for x := 0; x < 256; x++ {
+		px := dst.Pix[x*4 : x*4+4]
+		if px[3] != uint8(x) {
+			t.Errorf("alpha %d became %d", x, px[3])
+		}
+		if diff := int(px[0]) - x; diff < -2 || diff > 2 || (px[0]%4 != 0 && px[0] != 255) {
+			t.Errorf("channel %d became %d", x, px[0])
+		}
+	}
+}
+
+func TestFindExifBlockJPEG(t *testing.T) {
+	exif := concat([]byte("Exif\x00\x00"), []byte("MM\x00\x2a"), be32(8))
+	app1 := concat([]byte{0xFF, 0xE1}, []byte{0, byte(len(exif) + 2)}, exif)
+	soi := []byte{0xFF, 0xD8}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []byte
+	}{
+		{"exif segment", concat(soi, app1, []byte{0xFF, 0xDA}), exif},
+		{"after other segment", concat(soi, []byte{0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00}, app1), exif},
+		{"zero length segment", concat(soi, []byte{0xFF, 0xD0, 0x00, 0x00}, app1), nil},
+		{"one byte length segment", concat(soi, []byte{0xFF, 0xE1, 0x00, 0x01}, app1), nil},
+		{"length past end", concat(soi, []byte{0xFF, 0xE1, 0xFF, 0xFF}, exif), nil},
+	}
+	for _, tt := range tests {
+		if got := findExifBlock(tt.data, "jpeg"); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// useTestDatabase points the database and the blob store at a temporary
+// directory for the duration of a test
+func useTestDatabase(t *testing.T, database Database) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	oldDB, oldFile, oldStore := db, dataFile, blobStore
+	t.Cleanup(func() { db, dataFile, blobStore = oldDB, oldFile, oldStore })
+
+	db = database
+	dataFile = filepath.Join(dir, "database.json")
+	blobStore = &localBlobStore{Dir: dir}
+	return dir
+}
+
+func TestCollectGarbage(t *testing.T) {
+	name := func(c byte) string { return strings.Repeat(string(c), 64) + ".png" }
+	old := time.Now().Add(-48 * time.Hour)
+	recent := time.Now().Add(-time.Minute)
+
+	dir := useTestDatabase(t, Database{
+		TaskItems: []TaskItem{{ID: "item-a", TaskID: "task", Type: "image", UploadID: "a"}},
+		Uploads: []Upload{
+			{ID: "a", URL: "/static/" + name('a'), RefCount: 1, CreatedAt: old},
+			{ID: "b", URL: "/static/" + name('b'), CreatedAt: old},
+			{ID: "c", URL: "/static/" + name('c'), CreatedAt: old, UnreferencedAt: &recent},
+			{ID: "d", URL: "/static/" + name('d'), CreatedAt: old},
+		},
+	})
+	for _, file := range []string{name('a'), name('b'), name('c'), name('d'), "readme.txt"} {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, old, old)
+	}
+	os.Remove(filepath.Join(dir, "database.json"))
+
+	// Attached between the snapshot and the delete: the re-check keeps it
+	cutoff := time.Now().Add(-time.Hour)
+	db.TaskItems = append(db.TaskItems, TaskItem{ID: "item-d", TaskID: "task", Type: "image", UploadID: "d"})
+	if kept, err := deleteUnusedBlob(name('d'), cutoff); !kept || err != nil {
+		t.Errorf("deleteUnusedBlob of an attached upload: kept=%v err=%v", kept, err)
+	}
+	db.TaskItems = db.TaskItems[:1]
+	db.Uploads[3].UnreferencedAt = &recent
+
+	report, err := collectGarbage(time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Removed) != 1 || report.Removed[0].File != name('b') {
+		t.Errorf("removed = %+v, want only %s", report.Removed, name('b'))
+	}
+	if report.SkippedForeign != 1 || report.KeptRecent != 2 || report.RemovedRecords != 1 {
+		t.Errorf("report = %+v, want 1 foreign, 2 recent, 1 record", report)
+	}
+	for _, file := range []string{name('a'), name('c'), name('d'), "readme.txt"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("%s was deleted", file)
+		}
+	}
+	if len(db.Uploads) != 3 {
+		t.Errorf("%d upload records left, want 3", len(db.Uploads))
+	}
+}